@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+)
+
+// Upper bound for a single admin socket reply. Protects exporter from
+// allocating huge buffers when socket returns garbage instead of a length.
+const asokMaxReplySize = 64 << 20
+
+type asokRequest struct {
+	Prefix string `json:"prefix"`
+	Format string `json:"format"`
+}
+
+// Run command on ceph admin socket.
+// Wire protocol is the same one used by `ceph --admin-daemon`:
+// JSON encoded command terminated by a NUL byte is written to the socket,
+// daemon replies with 4 byte big endian payload length followed by payload.
+// Whole exchange is bound by context deadline, so hung daemon can't block caller.
+func AsokCommand(ctx context.Context, socket string, prefix string) ([]byte, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", socket)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return nil, err
+		}
+	}
+
+	request, err := json.Marshal(asokRequest{Prefix: prefix, Format: "json"})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(append(request, 0)); err != nil {
+		return nil, fmt.Errorf("asok %s: sending %q: %w", socket, prefix, err)
+	}
+
+	var length uint32
+	if err := binary.Read(conn, binary.BigEndian, &length); err != nil {
+		return nil, fmt.Errorf("asok %s: reading %q reply length: %w", socket, prefix, err)
+	}
+	if length > asokMaxReplySize {
+		return nil, fmt.Errorf("asok %s: %q reply too large: %d bytes", socket, prefix, length)
+	}
+	reply := make([]byte, length)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return nil, fmt.Errorf("asok %s: reading %q reply: %w", socket, prefix, err)
+	}
+	return reply, nil
+}
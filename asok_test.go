@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Start fake ceph admin socket which answers commands from replies map.
+// Returns socket path and a function which stops the server.
+func fakeAsokServer(t *testing.T, replies map[string]string) (string, func()) {
+	dir, err := ioutil.TempDir("", "ceph-exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	socket := filepath.Join(dir, "ceph-osd.0.asok")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				request, err := bufio.NewReader(conn).ReadBytes(0)
+				if err != nil {
+					return
+				}
+				var command asokRequest
+				if err := json.Unmarshal(request[:len(request)-1], &command); err != nil {
+					return
+				}
+				reply, ok := replies[command.Prefix]
+				if !ok {
+					// Daemon closes connection on unknown command
+					return
+				}
+				if err := binary.Write(conn, binary.BigEndian, uint32(len(reply))); err != nil {
+					return
+				}
+				if _, err := conn.Write([]byte(reply)); err != nil {
+					return
+				}
+			}(conn)
+		}
+	}()
+	return socket, func() {
+		listener.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestAsokCommand(t *testing.T) {
+	socket, stop := fakeAsokServer(t, map[string]string{
+		"perf dump": `{"osd":{"op":10}}`,
+		"empty":     ``,
+	})
+	defer stop()
+
+	reply, err := AsokCommand(context.Background(), socket, "perf dump")
+	if err != nil {
+		t.Fatalf("AsokCommand failed: %v", err)
+	}
+	if string(reply) != `{"osd":{"op":10}}` {
+		t.Errorf("AsokCommand returned wrong reply: %s", reply)
+	}
+	reply, err = AsokCommand(context.Background(), socket, "empty")
+	if err != nil || len(reply) != 0 {
+		t.Errorf("AsokCommand should return empty reply. Got: %q, %v", reply, err)
+	}
+	if _, err = AsokCommand(context.Background(), socket, "unknown"); err == nil {
+		t.Errorf("AsokCommand should fail when daemon closes connection")
+	}
+	if _, err = AsokCommand(context.Background(), socket+".missing", "perf dump"); err == nil {
+		t.Errorf("AsokCommand should fail on missing socket")
+	}
+}
+
+func TestAsokCommandTimeout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ceph-exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "ceph-osd.1.asok")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	// Hung daemon accepts connection but never replies
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(time.Second)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := AsokCommand(ctx, socket, "perf dump"); err == nil {
+		t.Errorf("AsokCommand should fail on hung daemon")
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Errorf("AsokCommand did not respect deadline. Took: %v", time.Since(start))
+	}
+}
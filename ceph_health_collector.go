@@ -1,60 +1,206 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
 	log "github.com/sirupsen/logrus"
 	"os/exec"
-	"regexp"
-	"strconv"
 	"strings"
 )
 
 type cephHealthStats struct {
+	Fsid   string `json:"fsid"`
 	Health struct {
-		Summary       interface{} `json:"summary"`
-		OverallStatus string      `json:"overall_status"`
-		Status        string      `json:"status"`
-		Checks        interface{} `json:"checks"`
+		OverallStatus string                     `json:"overall_status"`
+		Status        string                     `json:"status"`
+		Checks        map[string]cephHealthCheck `json:"checks"`
 	} `json:"health"`
+	OSDMap struct {
+		cephOSDMapSummary
+		// Before Nautilus summary is nested in osdmap.osdmap
+		OSDMap *cephOSDMapSummary `json:"osdmap"`
+	} `json:"osdmap"`
 	PGMap struct {
-		NumPGs                  float64 `json:"num_pgs"`
-		WriteOpPerSec           float64 `json:"write_op_per_sec"`
-		ReadOpPerSec            float64 `json:"read_op_per_sec"`
-		WriteBytePerSec         float64 `json:"write_bytes_sec"`
-		ReadBytePerSec          float64 `json:"read_bytes_sec"`
-		RecoveringObjectsPerSec float64 `json:"recovering_objects_per_sec"`
-		RecoveringBytePerSec    float64 `json:"recovering_bytes_per_sec"`
-		RecoveringKeysPerSec    float64 `json:"recovering_keys_per_sec"`
-		CacheFlushBytePerSec    float64 `json:"flush_bytes_sec"`
-		CacheEvictBytePerSec    float64 `json:"evict_bytes_sec"`
-		CachePromoteOpPerSec    float64 `json:"promote_op_per_sec"`
-		DegradedObjects         float64 `json:"degraded_objects"`
-		MisplacedObjects        float64 `json:"misplaced_objects"`
-		PGsByState              []struct {
-			Count  float64 `json:"count"`
-			States string  `json:"state_name"`
-		} `json:"pgs_by_state"`
+		NumPGs                  float64            `json:"num_pgs"`
+		WriteOpPerSec           float64            `json:"write_op_per_sec"`
+		ReadOpPerSec            float64            `json:"read_op_per_sec"`
+		WriteBytePerSec         float64            `json:"write_bytes_sec"`
+		ReadBytePerSec          float64            `json:"read_bytes_sec"`
+		RecoveringObjectsPerSec float64            `json:"recovering_objects_per_sec"`
+		RecoveringBytePerSec    float64            `json:"recovering_bytes_per_sec"`
+		RecoveringKeysPerSec    float64            `json:"recovering_keys_per_sec"`
+		CacheFlushBytePerSec    float64            `json:"flush_bytes_sec"`
+		CacheEvictBytePerSec    float64            `json:"evict_bytes_sec"`
+		CachePromoteOpPerSec    float64            `json:"promote_op_per_sec"`
+		DataBytes               float64            `json:"data_bytes"`
+		BytesUsed               float64            `json:"bytes_used"`
+		BytesAvail              float64            `json:"bytes_avail"`
+		BytesTotal              float64            `json:"bytes_total"`
+		NumObjects              float64            `json:"num_objects"`
+		DegradedObjects         float64            `json:"degraded_objects"`
+		MisplacedObjects        float64            `json:"misplaced_objects"`
+		PGsByState              []cephPGStateCount `json:"pgs_by_state"`
 	} `json:"pgmap"`
 }
 
+// Number of PGs in compound state, e.g. {"state_name": "active+clean+scrubbing+deep", "count": 3}
+type cephPGStateCount struct {
+	Count  float64 `json:"count"`
+	States string  `json:"state_name"`
+}
+
+// PG states which are always exported, even when no PG is in them.
+// https://docs.ceph.com/en/latest/rados/operations/pg-states/
+var pgStates = []string{
+	"activating", "active", "backfill_toofull", "backfill_unfound", "backfill_wait", "backfilling",
+	"clean", "creating", "deep", "degraded", "down", "failed_repair", "forced_backfill",
+	"forced_recovery", "incomplete", "inconsistent", "laggy", "peered", "peering", "premerge",
+	"recovering", "recovery_toofull", "recovery_unfound", "recovery_wait", "remapped", "repair",
+	"scrubbing", "snaptrim", "snaptrim_error", "snaptrim_wait", "stale", "undersized", "unknown", "wait",
+}
+
+// Legacy PG metrics and PG state they are taken from
+var legacyPGStateMetrics = map[string]struct{ state, help string }{
+	"ceph_cluster_pgs_degraded":         {"degraded", "Number of degraded PGs"},
+	"ceph_cluster_pgs_undersized":       {"undersized", "Number of undersized PGs"},
+	"ceph_cluster_pgs_backfill":         {"backfilling", "Number of PGs backfilling"},
+	"ceph_cluster_pgs_backfill_toofull": {"backfill_toofull", "Number of PGs too full"},
+	"ceph_cluster_pgs_backfill_wait":    {"backfill_wait", "Number of PGs waiting to backfill"},
+	"ceph_cluster_pgs_recovery_wait":    {"recovery_wait", "Number of PGs waiting for recovery"},
+	"ceph_cluster_pgs_peering":          {"peering", "Number of peering PGs"},
+}
+
+// Split compound PG states into component states and sum PGs in every state
+func PGStateCounts(pgsByState []cephPGStateCount) map[string]float64 {
+	counts := make(map[string]float64, len(pgStates))
+	for _, state := range pgStates {
+		counts[state] = 0
+	}
+	for _, compound := range pgsByState {
+		for _, state := range strings.Split(compound.States, "+") {
+			counts[state] += compound.Count
+		}
+	}
+	return counts
+}
+
+// Export PG states from pgmap, they are accurate even when there's no health warning
+func PGStateMetrics(stats *cephHealthStats) []cephHealthData {
+	counts := PGStateCounts(stats.PGMap.PGsByState)
+	metrics := []cephHealthData{
+		{name: "ceph_pg_total", value: stats.PGMap.NumPGs, metricType: GaugeValue, help: "Total number of PGs"},
+	}
+	for state, count := range counts {
+		metrics = append(metrics, cephHealthData{name: "ceph_pg_state", value: count, metricType: GaugeValue, help: "Number of PGs in state, PG is counted in every state of its compound state", labels: map[string]string{"state": state}})
+	}
+	for name, legacy := range legacyPGStateMetrics {
+		metrics = append(metrics, cephHealthData{name: name, value: counts[legacy.state], metricType: GaugeValue, help: legacy.help})
+	}
+	return metrics
+}
+
+// Export cluster IO, recovery and capacity from pgmap
+func PGMapMetrics(stats *cephHealthStats) []cephHealthData {
+	pgmap := stats.PGMap
+	return []cephHealthData{
+		{name: "ceph_cluster_read_ops_per_second", value: pgmap.ReadOpPerSec, metricType: GaugeValue, help: "Client read operations per second"},
+		{name: "ceph_cluster_write_ops_per_second", value: pgmap.WriteOpPerSec, metricType: GaugeValue, help: "Client write operations per second"},
+		{name: "ceph_cluster_read_bytes_per_second", value: pgmap.ReadBytePerSec, metricType: GaugeValue, help: "Client read bytes per second"},
+		{name: "ceph_cluster_write_bytes_per_second", value: pgmap.WriteBytePerSec, metricType: GaugeValue, help: "Client write bytes per second"},
+		{name: "ceph_cluster_recovering_objects_per_second", value: pgmap.RecoveringObjectsPerSec, metricType: GaugeValue, help: "Recovered objects per second"},
+		{name: "ceph_cluster_recovering_bytes_per_second", value: pgmap.RecoveringBytePerSec, metricType: GaugeValue, help: "Recovered bytes per second"},
+		{name: "ceph_cluster_recovering_keys_per_second", value: pgmap.RecoveringKeysPerSec, metricType: GaugeValue, help: "Recovered omap keys per second"},
+		{name: "ceph_cluster_cache_flush_bytes_per_second", value: pgmap.CacheFlushBytePerSec, metricType: GaugeValue, help: "Cache tier flushed bytes per second"},
+		{name: "ceph_cluster_cache_evict_bytes_per_second", value: pgmap.CacheEvictBytePerSec, metricType: GaugeValue, help: "Cache tier evicted bytes per second"},
+		{name: "ceph_cluster_cache_promote_ops_per_second", value: pgmap.CachePromoteOpPerSec, metricType: GaugeValue, help: "Cache tier promote operations per second"},
+		{name: "ceph_cluster_bytes_total", value: pgmap.BytesTotal, metricType: GaugeValue, help: "Raw capacity of a cluster"},
+		{name: "ceph_cluster_bytes_used", value: pgmap.BytesUsed, metricType: GaugeValue, help: "Raw used capacity of a cluster"},
+		{name: "ceph_cluster_bytes_avail", value: pgmap.BytesAvail, metricType: GaugeValue, help: "Raw available capacity of a cluster"},
+		{name: "ceph_cluster_data_bytes", value: pgmap.DataBytes, metricType: GaugeValue, help: "Stored data size before replication"},
+		{name: "ceph_cluster_objects", value: pgmap.NumObjects, metricType: GaugeValue, help: "Number of objects in a cluster"},
+		{name: "ceph_cluster_objects_degraded", value: pgmap.DegradedObjects, metricType: GaugeValue, help: "Number of degraded objects in a cluster"},
+		{name: "ceph_cluster_objects_misplaced", value: pgmap.MisplacedObjects, metricType: GaugeValue, help: "Number of misplaced objects in a cluster"},
+	}
+}
+
+// OSD map summary of `ceph status`
+type cephOSDMapSummary struct {
+	Epoch          float64 `json:"epoch"`
+	NumOSDs        float64 `json:"num_osds"`
+	NumUpOSDs      float64 `json:"num_up_osds"`
+	NumInOSDs      float64 `json:"num_in_osds"`
+	NumRemappedPGs float64 `json:"num_remapped_pgs"`
+}
+
+// Export OSD map summary
+func OSDMapMetrics(stats *cephHealthStats) []cephHealthData {
+	osdmap := stats.OSDMap.cephOSDMapSummary
+	if stats.OSDMap.OSDMap != nil {
+		osdmap = *stats.OSDMap.OSDMap
+	}
+	return []cephHealthData{
+		{name: "ceph_osdmap_epoch", value: osdmap.Epoch, metricType: GaugeValue, help: "Current OSD map epoch"},
+		{name: "ceph_cluster_osds", value: osdmap.NumOSDs, metricType: GaugeValue, help: "Number of OSDs in OSD map"},
+		{name: "ceph_cluster_osds_up", value: osdmap.NumUpOSDs, metricType: GaugeValue, help: "Number of up OSDs"},
+		{name: "ceph_cluster_osds_in", value: osdmap.NumInOSDs, metricType: GaugeValue, help: "Number of in OSDs"},
+		{name: "ceph_cluster_pgs_remapped", value: osdmap.NumRemappedPGs, metricType: GaugeValue, help: "Number of remapped PGs"},
+	}
+}
+
+// Single health check, e.g. "OSD_DOWN": {"severity": "HEALTH_WARN", "summary": {"message": "1 osds down", "count": 1}}
+type cephHealthCheck struct {
+	Severity string `json:"severity"`
+	Summary  struct {
+		Message string  `json:"message"`
+		Count   float64 `json:"count"`
+	} `json:"summary"`
+	Muted bool `json:"muted"`
+}
+
+// Cluster wide metric. Metrics of the same name always have the same label names.
 type cephHealthData struct {
+	name       string
 	value      float64
 	metricType float64
 	help       string
+	fsid       string
+	labels     map[string]string
 }
 
-func CephHealthCollector() map[string]cephHealthData {
+// Sorted label names and matching values of a cluster metric
+func (data cephHealthData) LabelPairs() ([]string, []string) {
+	return perfCounter{labels: data.labels}.LabelPairs()
+}
+
+// Collect cluster metrics with ceph CLI. All commands share deadline of collection cycle.
+func CephHealthCollector(ctx context.Context) []cephHealthData {
+	metrics := ParseCephHealth(CephCommand(ctx, "status"))
+	fsid := metrics[0].fsid
+	osdDump := CephCommand(ctx, "osd", "dump")
+	metrics = append(metrics, ParseOSDDump(osdDump)...)
+	osdTree := CephCommand(ctx, "osd", "tree")
+	metrics = append(metrics, ParseOSDStates(osdDump, osdTree)...)
+	metrics = append(metrics, ParseOSDDF(CephCommand(ctx, "osd", "df"), osdTree)...)
+	metrics = append(metrics, ParseDFDetail(CephCommand(ctx, "df", "detail"))...)
+	metrics = append(metrics, ParsePoolStats(CephCommand(ctx, "osd", "pool", "stats"))...)
+	// Not every command reports fsid, use the one from ceph status
+	for i := range metrics {
+		if metrics[i].fsid == "" {
+			metrics[i].fsid = fsid
+		}
+	}
+	return metrics
+}
+
+// Build cluster metrics from `ceph status` output
+func ParseCephHealth(status []byte) []cephHealthData {
 	stats := &cephHealthStats{}
-	if err := json.Unmarshal(CephHealthCommand(), stats); err != nil {
+	if err := json.Unmarshal(status, stats); err != nil {
 		log.Debug(err)
 	}
-	//var healthData = make(map[string]interface{})
 	healthData := make(map[string]cephHealthData)
-	var healthString string
 	var cephHealthStatus float64
 	var cephHealthStatusString string
-	var nooutFlag float64
 
 	if stats.Health.Status != "" {
 		cephHealthStatusString = stats.Health.Status
@@ -74,179 +220,45 @@ func CephHealthCollector() map[string]cephHealthData {
 	}
 
 	healthData["ceph_cluster_health_status"] = cephHealthData{value: cephHealthStatus, metricType: GaugeValue, help: "Ceph cluster health status (ok:0, warning:1, error:2)"}
-	healthData["ceph_cluster_noout_flag"] = cephHealthData{value: 0, metricType: GaugeValue, help: "Status of noout flag (0:unset, 1:set)"}
-
-	healthData["ceph_cluster_pgs_degraded"] = cephHealthData{value: 0, metricType: GaugeValue, help: "Number of degraded PGs"}
-	healthData["ceph_cluster_pgs_undersized"] = cephHealthData{value: 0, metricType: GaugeValue, help: "Number of undersized PGs"}
-	healthData["ceph_cluster_pgs_stuck_degraded"] = cephHealthData{value: 0, metricType: GaugeValue, help: "Number of stuck degraded PGs"}
-	healthData["ceph_cluster_pgs_stuck_unclean"] = cephHealthData{value: 0, metricType: GaugeValue, help: "Number of stuck unclean PGs"}
-	healthData["ceph_cluster_pgs_backfill"] = cephHealthData{value: 0, metricType: GaugeValue, help: "Number of PGs backfilling"}
-	healthData["ceph_cluster_pgs_backfill_toofull"] = cephHealthData{value: 0, metricType: GaugeValue, help: "Number of PGs too full"}
-	healthData["ceph_cluster_pgs_backfill_wait"] = cephHealthData{value: 0, metricType: GaugeValue, help: "Number of PGs waiting to backfill"}
-	healthData["ceph_cluster_pgs_recovery_wait"] = cephHealthData{value: 0, metricType: GaugeValue, help: "Number of PGs waiting for recovery"}
-	healthData["ceph_cluster_pgs_peering"] = cephHealthData{value: 0, metricType: GaugeValue, help: "Number of peering PGs"}
-	healthData["ceph_cluster_objects_degraded"] = cephHealthData{value: 0, metricType: GaugeValue, help: "Number of degraded objects in a cluster"}
-	healthData["ceph_cluster_objects_misplaced"] = cephHealthData{value: 0, metricType: GaugeValue, help: "Number of misplaced objects in a cluster"}
-
-	// Check if using new Nautilus status data structure.
-	if stats.Health.Checks != nil {
-		log.Debug("Using new status data structure")
-		// Convert whole Health.Checks interface to string.
-		healthString = fmt.Sprintf("%v", stats.Health.Checks)
-	} else {
-		log.Debug("Using old status data structure")
-		// Convert whole Health.Checks interface to string.
-		healthString = fmt.Sprintf("%v", stats.Health.Summary)
-	}
 
-	if strings.Contains(healthString, "noout flag(s) set") {
-		nooutFlag = 1
-	} else {
-		nooutFlag = 0
-	}
-	var tmp = healthData["ceph_cluster_noout_flag"]
-	tmp.value = float64(nooutFlag)
-	healthData["ceph_cluster_noout_flag"] = tmp
-
-	// Do regex match against status string and build metrics
-	re := regexp.MustCompile(`([\d]+) pgs degraded`)
-	result := re.FindStringSubmatch(healthString)
-	if len(result) == 2 {
-		val, err := strconv.Atoi(result[1])
-		if err != nil {
-			log.Debug(err)
-		} else {
-			var tmp = healthData["ceph_cluster_pgs_degraded"]
-			tmp.value = float64(val)
-			healthData["ceph_cluster_pgs_degraded"] = tmp
-		}
+	var metrics []cephHealthData
+	for metricName, metricData := range healthData {
+		metricData.name = metricName
+		metrics = append(metrics, metricData)
 	}
-	re = regexp.MustCompile(`([\d]+) pgs undersized`)
-	result = re.FindStringSubmatch(healthString)
-	if len(result) == 2 {
-		val, err := strconv.Atoi(result[1])
-		if err != nil {
-			log.Debug(err)
-		} else {
-			var tmp = healthData["ceph_cluster_pgs_undersized"]
-			tmp.value = float64(val)
-			healthData["ceph_cluster_pgs_undersized"] = tmp
-		}
-	}
-	re = regexp.MustCompile(`([\d]+) pgs stuck degraded`)
-	result = re.FindStringSubmatch(healthString)
-	if len(result) == 2 {
-		val, err := strconv.Atoi(result[1])
-		if err != nil {
-			log.Debug(err)
-		} else {
-			var tmp = healthData["ceph_cluster_pgs_stuck_degraded"]
-			tmp.value = float64(val)
-			healthData["ceph_cluster_pgs_stuck_degraded"] = tmp
-		}
-	}
-	re = regexp.MustCompile(`([\d]+) pgs stuck unclean`)
-	result = re.FindStringSubmatch(healthString)
-	if len(result) == 2 {
-		val, err := strconv.Atoi(result[1])
-		if err != nil {
-			log.Debug(err)
-		} else {
-			var tmp = healthData["ceph_cluster_pgs_stuck_unclean"]
-			tmp.value = float64(val)
-			healthData["ceph_cluster_pgs_stuck_unclean"] = tmp
-		}
-	}
-	re = regexp.MustCompile(`([\d]+) pgs backfilling`)
-	result = re.FindStringSubmatch(healthString)
-	if len(result) == 2 {
-		val, err := strconv.Atoi(result[1])
-		if err != nil {
-			log.Debug(err)
-		} else {
-			var tmp = healthData["ceph_cluster_pgs_backfill"]
-			tmp.value = float64(val)
-			healthData["ceph_cluster_pgs_backfill"] = tmp
-		}
-	}
-	re = regexp.MustCompile(`([\d]+) pgs backfill_toofull`)
-	result = re.FindStringSubmatch(healthString)
-	if len(result) == 2 {
-		val, err := strconv.Atoi(result[1])
-		if err != nil {
-			log.Debug(err)
-		} else {
-			var tmp = healthData["ceph_cluster_pgs_backfill_toofull"]
-			tmp.value = float64(val)
-			healthData["ceph_cluster_pgs_backfill_toofull"] = tmp
-		}
-	}
-	re = regexp.MustCompile(`([\d]+) pgs backfill_wait`)
-	result = re.FindStringSubmatch(healthString)
-	if len(result) == 2 {
-		val, err := strconv.Atoi(result[1])
-		if err != nil {
-			log.Debug(err)
-		} else {
-			var tmp = healthData["ceph_cluster_pgs_backfill_wait"]
-			tmp.value = float64(val)
-			healthData["ceph_cluster_pgs_backfill_wait"] = tmp
-		}
-	}
-	re = regexp.MustCompile(`([\d]+) pgs recovery_wait`)
-	result = re.FindStringSubmatch(healthString)
-	if len(result) == 2 {
-		val, err := strconv.Atoi(result[1])
-		if err != nil {
-			log.Debug(err)
-		} else {
-			var tmp = healthData["ceph_cluster_pgs_recovery_wait"]
-			tmp.value = float64(val)
-			healthData["ceph_cluster_pgs_recovery_wait"] = tmp
-		}
-	}
-	re = regexp.MustCompile(`([\d]+) pgs peering`)
-	result = re.FindStringSubmatch(healthString)
-	if len(result) == 2 {
-		val, err := strconv.Atoi(result[1])
-		if err != nil {
-			log.Debug(err)
-		} else {
-			var tmp = healthData["ceph_cluster_pgs_peering"]
-			tmp.value = float64(val)
-			healthData["ceph_cluster_pgs_peering"] = tmp
-		}
-	}
-	re = regexp.MustCompile(`([\d]+)/([\d]+) objects degraded`)
-	result = re.FindStringSubmatch(healthString)
-	if len(result) == 3 {
-		val, err := strconv.Atoi(result[1])
-		if err != nil {
-			log.Debug(err)
-		} else {
-			var tmp = healthData["ceph_cluster_objects_degraded"]
-			tmp.value = float64(val)
-			healthData["ceph_cluster_objects_degraded"] = tmp
-		}
-	}
-	re = regexp.MustCompile(`([\d]+)/([\d]+) objects misplaced`)
-	result = re.FindStringSubmatch(healthString)
-	if len(result) == 3 {
-		val, err := strconv.Atoi(result[1])
-		if err != nil {
-			log.Debug(err)
-		} else {
-			var tmp = healthData["ceph_cluster_objects_misplaced"]
-			tmp.value = float64(val)
-			healthData["ceph_cluster_objects_misplaced"] = tmp
-		}
+	metrics = append(metrics, HealthCheckMetrics(stats.Health.Checks)...)
+	metrics = append(metrics, PGStateMetrics(stats)...)
+	metrics = append(metrics, PGMapMetrics(stats)...)
+	metrics = append(metrics, OSDMapMetrics(stats)...)
+	for i := range metrics {
+		metrics[i].fsid = stats.Fsid
 	}
+	return metrics
+}
 
-	return healthData
+// Export every health check, so new check codes need no changes in exporter
+func HealthCheckMetrics(checks map[string]cephHealthCheck) []cephHealthData {
+	var metrics []cephHealthData
+	for name, check := range checks {
+		labels := map[string]string{"name": name, "severity": check.Severity}
+		metrics = append(metrics,
+			cephHealthData{name: "ceph_health_check", value: 1, metricType: GaugeValue, help: "Active health check (1:active)", labels: labels},
+			cephHealthData{name: "ceph_health_check_count", value: check.Summary.Count, metricType: GaugeValue, help: "Number of items (e.g. OSDs or PGs) affected by health check", labels: labels},
+		)
+	}
+	return metrics
 }
 
-func CephHealthCommand() []byte {
-	log.Debug("Running ceph status")
-	cmdOutput, _ := exec.Command("ceph", "-c", *cephConfigFile, "status", "-f", "json").Output()
+// Run ceph CLI command with JSON output, e.g. CephCommand(ctx, "osd", "dump")
+func CephCommand(ctx context.Context, args ...string) []byte {
+	command := strings.Join(args, " ")
+	log.Debug("Running ceph ", command)
+	ctx, cancel := CommandContext(ctx)
+	defer cancel()
+	args = append([]string{"-c", *cephConfigFile}, append(args, "-f", "json")...)
+	cmdOutput, err := exec.CommandContext(ctx, "ceph", args...).Output()
+	if err != nil {
+		log.Warn("ceph ", command, " failed: ", err)
+	}
 	return cmdOutput
 }
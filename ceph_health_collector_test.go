@@ -1,46 +1,143 @@
 package main
 
 import (
-	"reflect"
+	"context"
 	"testing"
 )
 
+// Find cluster metric by name and label values
+func findHealthMetric(metrics []cephHealthData, name string, labels map[string]string) (cephHealthData, bool) {
+	for _, metric := range metrics {
+		if metric.name != name || len(metric.labels) != len(labels) {
+			continue
+		}
+		matches := true
+		for label, value := range labels {
+			if metric.labels[label] != value {
+				matches = false
+			}
+		}
+		if matches {
+			return metric, true
+		}
+	}
+	return cephHealthData{}, false
+}
+
 func TestCephHealthCollector(t *testing.T) {
-	health := CephHealthCollector()
-	if reflect.TypeOf(health["ceph_cluster_pgs_degraded"]).String() != "main.cephHealthData" {
-		t.Errorf("health[ceph_cluster_pgs_degraded] has wrong data")
+	health := CephHealthCollector(context.Background())
+	for _, name := range []string{
+		"ceph_cluster_health_status",
+		"ceph_cluster_pgs_degraded",
+		"ceph_cluster_pgs_undersized",
+		"ceph_cluster_pgs_backfill",
+		"ceph_cluster_pgs_backfill_toofull",
+		"ceph_cluster_pgs_backfill_wait",
+		"ceph_cluster_pgs_recovery_wait",
+		"ceph_cluster_pgs_peering",
+		"ceph_cluster_objects_degraded",
+		"ceph_cluster_objects_misplaced",
+	} {
+		if _, ok := findHealthMetric(health, name, nil); !ok {
+			t.Errorf("health[%s] is missing", name)
+		}
+	}
+}
+
+func TestParseCephHealth(t *testing.T) {
+	health := ParseCephHealth([]byte(`{
+		"fsid": "0b3a1b0c-5f7e-11ee-8c99-0242ac120002",
+		"health": {
+			"status": "HEALTH_WARN",
+			"checks": {
+				"OSDMAP_FLAGS": {"severity": "HEALTH_WARN", "summary": {"message": "noout flag(s) set", "count": 1}, "muted": false},
+				"OSD_DOWN": {"severity": "HEALTH_WARN", "summary": {"message": "2 osds down", "count": 2}, "muted": false},
+				"PG_DEGRADED": {"severity": "HEALTH_WARN", "summary": {"message": "Degraded data redundancy: 10/300 objects degraded (3.333%), 5 pgs degraded", "count": 5}, "muted": false}
+			}
+		},
+		"osdmap": {"epoch": 42, "num_osds": 6, "num_up_osds": 4, "num_in_osds": 5, "num_remapped_pgs": 2},
+		"pgmap": {
+			"num_pgs": 128,
+			"num_objects": 3000,
+			"data_bytes": 1000000,
+			"bytes_used": 3500000,
+			"bytes_avail": 6500000,
+			"bytes_total": 10000000,
+			"degraded_objects": 10,
+			"read_bytes_sec": 4096,
+			"write_op_per_sec": 12,
+			"recovering_objects_per_sec": 3,
+			"pgs_by_state": [
+				{"state_name": "active+clean", "count": 100},
+				{"state_name": "active+clean+scrubbing+deep", "count": 3},
+				{"state_name": "active+undersized+degraded", "count": 5},
+				{"state_name": "active+remapped+backfilling", "count": 2},
+				{"state_name": "peering", "count": 18}
+			]
+		}
+	}`))
+	status, _ := findHealthMetric(health, "ceph_cluster_health_status", nil)
+	if status.value != 1 || status.fsid != "0b3a1b0c-5f7e-11ee-8c99-0242ac120002" {
+		t.Errorf("Wrong health status: %+v", status)
 	}
-	if reflect.TypeOf(health["ceph_cluster_pgs_undersized"]).String() != "main.cephHealthData" {
-		t.Errorf("health[ceph_cluster_pgs_undersized] has wrong data")
+	check, ok := findHealthMetric(health, "ceph_health_check", map[string]string{"name": "OSD_DOWN", "severity": "HEALTH_WARN"})
+	if !ok || check.value != 1 {
+		t.Errorf("OSD_DOWN health check is missing: %v", health)
 	}
-	if reflect.TypeOf(health["ceph_cluster_pgs_stuck_degraded"]).String() != "main.cephHealthData" {
-		t.Errorf("health[ceph_cluster_pgs_stuck_degraded] has wrong data")
+	count, _ := findHealthMetric(health, "ceph_health_check_count", map[string]string{"name": "OSD_DOWN", "severity": "HEALTH_WARN"})
+	if count.value != 2 {
+		t.Errorf("Wrong OSD_DOWN health check count. Got: %v, needed: 2", count.value)
 	}
-	if reflect.TypeOf(health["ceph_cluster_pgs_stuck_unclean"]).String() != "main.cephHealthData" {
-		t.Errorf("health[ceph_cluster_pgs_degraded] has wrong data")
+	if degraded, _ := findHealthMetric(health, "ceph_cluster_pgs_degraded", nil); degraded.value != 5 {
+		t.Errorf("Wrong degraded PGs. Got: %v, needed: 5", degraded.value)
 	}
-	if reflect.TypeOf(health["ceph_cluster_pgs_backfill"]).String() != "main.cephHealthData" {
-		t.Errorf("health[ceph_cluster_pgs_backfill] has wrong data")
+	if total, _ := findHealthMetric(health, "ceph_pg_total", nil); total.value != 128 {
+		t.Errorf("Wrong total PGs. Got: %v, needed: 128", total.value)
 	}
-	if reflect.TypeOf(health["ceph_cluster_pgs_backfill_toofull"]).String() != "main.cephHealthData" {
-		t.Errorf("health[ceph_cluster_pgs_backfill_toofull] has wrong data")
+	states := map[string]float64{"active": 110, "clean": 103, "deep": 3, "degraded": 5, "backfilling": 2, "peering": 18, "recovering": 0}
+	for state, expected := range states {
+		metric, ok := findHealthMetric(health, "ceph_pg_state", map[string]string{"state": state})
+		if !ok || metric.value != expected {
+			t.Errorf("Wrong PGs in state %s. Got: %v, needed: %v", state, metric.value, expected)
+		}
 	}
-	if reflect.TypeOf(health["ceph_cluster_pgs_backfill_wait"]).String() != "main.cephHealthData" {
-		t.Errorf("health[ceph_cluster_pgs_backfill_wait] has wrong data")
+	pgmap := map[string]float64{
+		"ceph_cluster_objects":                       3000,
+		"ceph_cluster_data_bytes":                    1000000,
+		"ceph_cluster_bytes_used":                    3500000,
+		"ceph_cluster_bytes_avail":                   6500000,
+		"ceph_cluster_bytes_total":                   10000000,
+		"ceph_cluster_objects_degraded":              10,
+		"ceph_cluster_objects_misplaced":             0,
+		"ceph_cluster_read_bytes_per_second":         4096,
+		"ceph_cluster_write_ops_per_second":          12,
+		"ceph_cluster_recovering_objects_per_second": 3,
 	}
-	if reflect.TypeOf(health["ceph_cluster_pgs_recovery_wait"]).String() != "main.cephHealthData" {
-		t.Errorf("health[ceph_cluster_pgs_recovery_wait] has wrong data")
+	for name, expected := range pgmap {
+		if metric, ok := findHealthMetric(health, name, nil); !ok || metric.value != expected {
+			t.Errorf("Wrong %s. Got: %v, needed: %v", name, metric.value, expected)
+		}
 	}
-	if reflect.TypeOf(health["ceph_cluster_pgs_peering"]).String() != "main.cephHealthData" {
-		t.Errorf("health[ceph_cluster_pgs_peering] has wrong data")
+	if backfill, _ := findHealthMetric(health, "ceph_cluster_pgs_backfill", nil); backfill.value != 2 {
+		t.Errorf("Legacy backfill PGs should come from PG states. Got: %v", backfill.value)
 	}
-	if reflect.TypeOf(health["ceph_cluster_objects_degraded"]).String() != "main.cephHealthData" {
-		t.Errorf("health[ceph_cluster_objects_degraded] has wrong data")
+	osdmap := map[string]float64{
+		"ceph_osdmap_epoch":         42,
+		"ceph_cluster_osds":         6,
+		"ceph_cluster_osds_up":      4,
+		"ceph_cluster_osds_in":      5,
+		"ceph_cluster_pgs_remapped": 2,
 	}
-	if reflect.TypeOf(health["ceph_cluster_objects_misplaced"]).String() != "main.cephHealthData" {
-		t.Errorf("health[ceph_cluster_objects_misplaced] has wrong data")
+	for name, expected := range osdmap {
+		if metric, ok := findHealthMetric(health, name, nil); !ok || metric.value != expected {
+			t.Errorf("Wrong %s. Got: %v, needed: %v", name, metric.value, expected)
+		}
 	}
-	if reflect.TypeOf(health["ceph_cluster_noout_flag"]).String() != "main.cephHealthData" {
-		t.Errorf("health[ceph_cluster_noout_flag] has wrong data")
+}
+
+func TestParseCephHealthNestedOSDMap(t *testing.T) {
+	health := ParseCephHealth([]byte(`{"osdmap": {"osdmap": {"epoch": 7, "num_osds": 3, "num_up_osds": 3, "num_in_osds": 3, "num_remapped_pgs": 0}}}`))
+	if osds, _ := findHealthMetric(health, "ceph_cluster_osds", nil); osds.value != 3 {
+		t.Errorf("Pre Nautilus osdmap should be parsed. Got: %v", osds.value)
 	}
 }
@@ -1,28 +1,45 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"reflect"
-	"regexp"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
-var schema = make(map[string]string)
-var cephMetrics = make(map[string]interface{})
-var cephDevice = make(map[string]interface{})
-var osdSchema = make(map[string]interface{})
-var clusterHealth = make(map[string]cephHealthData)
-var mutex = sync.RWMutex{}
+var schema = make(map[string]*schemaCacheEntry)
+var schemaMutex = sync.Mutex{}
+
+// Stored schema together with identity of daemon it was taken from.
+// Schema is refreshed when socket is recreated (daemon restart) or daemon version changes.
+type schemaCacheEntry struct {
+	schemas map[string]string
+	inode   uint64
+	mtime   time.Time
+	version string
+	stale   bool
+	// Commands daemon does not support, e.g. "counter schema" before Reef
+	unsupported map[string]bool
+	// Process owning client socket
+	peer *socketPeer
+}
+
+var collectTrigger = make(chan struct{}, 1)
+
+// Last complete collection cycle result, see CurrentSnapshot
+var snapshot atomic.Value
 
 const (
-	GaugeValue = 2
+	GaugeValue   = 2
+	CounterValue = 10
 )
 
 type cephCollector struct {
@@ -36,57 +53,97 @@ func (collector *cephCollector) Describe(ch chan<- *prometheus.Desc) {
 
 }
 
-func LoadJson(jsonData string) map[string]interface{} {
+func LoadJson(jsonData string) (map[string]interface{}, error) {
 	var result map[string]interface{}
 	err := json.Unmarshal([]byte(jsonData), &result)
 	if err != nil {
-		log.Error("Error loading json: ", err)
-	}
-	// Workaround for radosgw client metrics.
-	// Removing hostname from metric name.
-	// We need only host name, not FQDN.
-	name, _ := os.Hostname()
-	var re = regexp.MustCompile(`^([^.]+)`)
-	key := "client.radosgw." + re.FindString(name)
-	if result[key] != nil {
-		log.Debug("Key with hostname found, renaming")
-		result["client.radosgw"] = result[key]
-		delete(result, key)
+		log.Debug("Error loading json: ", err)
+		return nil, err
 	}
-	return result
+	return result, nil
+}
+
+// Metric prefixes of known daemon types.
+// Other daemon types are exported as ceph_<daemon_type>.
+var daemonMetricPrefix = map[string]string{
+	"mon":           "ceph_monitor",
+	"osd":           "ceph_osd",
+	"mgr":           "ceph_mgr",
+	"mds":           "ceph_mds",
+	"rgw":           "ceph_radosgw",
+	"rbd-mirror":    "ceph_rbd_mirror",
+	"cephfs-mirror": "ceph_cephfs_mirror",
+	"client":        "ceph_client",
 }
 
+// Daemons running as ceph clients, e.g. client.rbd-mirror.host.asok.
+// Remaining clients (client.admin, librbd, ...) are of "client" type.
+var clientDaemonTypes = map[string]string{
+	"rgw":           "rgw",
+	"radosgw":       "rgw",
+	"rbd-mirror":    "rbd-mirror",
+	"cephfs-mirror": "cephfs-mirror",
+}
+
+// Labels identifying daemon on every per-daemon metric
+var daemonLabelNames = []string{"cluster", "ceph_daemon", "daemon_type", "hostname", "fsid"}
+
+var hostname, _ = os.Hostname()
+
+// Parse admin socket file name <cluster>-<type>.<id>.asok into daemon identity.
+// E.g. ceph-osd.12.asok, ceph-mon.a.asok, ceph-client.rgw.zone1.host.asok.
+// Client sockets named $cluster-$name.$pid.$cctid.asok keep pid and cctid in ceph_daemon,
+// otherwise several processes of the same client (e.g. VMs) would clash.
+// Returned "type" is metric prefix, it's empty only when socket name has no daemon type.
 func GetDeviceType(socketName string) map[string]string {
 	var device = make(map[string]string)
 	log.Debug("Getting device info for ", socketName)
-	if strings.Contains(socketName, "mon.") {
-		log.Debug("Device is a monitor")
-		var re = regexp.MustCompile(`mon(.[0-9]*)`)
-		device["type"] = "ceph_monitor"
-		device["name"] = strings.ReplaceAll(re.FindString(socketName), ".", "")
-	}
-	if strings.Contains(socketName, "osd.") {
-		log.Debug("Device is a osd")
-		var re = regexp.MustCompile(`osd(.[0-9]*)`)
-		device["type"] = "ceph_osd"
-		device["name"] = strings.ReplaceAll(re.FindString(socketName), ".", "")
-	}
-	if strings.Contains(socketName, "radosgw.") {
-		log.Debug("Device is a radosgw")
-		var re = regexp.MustCompile(`radosgw(.[0-9]*)`)
-		device["type"] = "ceph_radosgw"
-		device["name"] = strings.ReplaceAll(re.FindString(socketName), ".", "")
-	}
-	if strings.Contains(socketName, "mgr.") {
-		log.Debug("Device is a mgr")
-		var re = regexp.MustCompile(`mgr(.[0-9]*)`)
-		device["type"] = "ceph_mgr"
-		device["name"] = strings.ReplaceAll(re.FindString(socketName), ".", "")
+	name := strings.TrimSuffix(filepath.Base(socketName), ".asok")
+	device["cluster"] = "ceph"
+	// Cluster name may contain dashes, daemon type never does
+	if dot := strings.Index(name, "."); dot > 0 {
+		if dash := strings.LastIndex(name[:dot], "-"); dash > 0 {
+			device["cluster"] = name[:dash]
+			name = name[dash+1:]
+		}
+	}
+	daemonType := strings.SplitN(name, ".", 2)[0]
+	if daemonType == "client" {
+		if parts := strings.SplitN(name, ".", 3); len(parts) > 1 && clientDaemonTypes[parts[1]] != "" {
+			daemonType = clientDaemonTypes[parts[1]]
+		}
+	}
+	device["ceph_daemon"] = name
+	device["daemon_type"] = daemonType
+	device["hostname"] = hostname
+	device["fsid"] = SocketFsid(socketName)
+	device["type"] = daemonMetricPrefix[daemonType]
+	if device["type"] == "" && daemonType != "" {
+		// Generic fallback, any socket answering perf dump is exported
+		device["type"] = "ceph_" + CephNormalizeMetricName(daemonType)
 	}
 	log.Debug("Device:", device)
 	return device
 }
 
+// Values of daemonLabelNames for a device
+func DaemonLabelValues(device map[string]string) []string {
+	values := make([]string, 0, len(daemonLabelNames))
+	for _, name := range daemonLabelNames {
+		values = append(values, device[name])
+	}
+	return values
+}
+
+// Request collection cycle right away, without waiting for timer.
+// Requests arriving while collection is pending are coalesced into one.
+func TriggerCollection() {
+	select {
+	case collectTrigger <- struct{}{}:
+	default:
+	}
+}
+
 func CollectTimer(queryInterval int) {
 	tickChan := time.NewTicker(time.Second * time.Duration(queryInterval))
 	quit := make(chan struct{})
@@ -95,73 +152,278 @@ func CollectTimer(queryInterval int) {
 		case <-tickChan.C:
 			log.Debug("Collector timer triggered")
 			Collector()
+		case <-collectTrigger:
+			log.Debug("Collector triggered by socket event")
+			Collector()
 		case <-quit:
 			return
 		}
 	}
 }
 
+// Complete result of a single collection cycle.
+// Snapshot is never modified after it's stored, so it can be read without locking.
+type cephSnapshot struct {
+	devices  map[string]map[string]string
+	counters map[string][]perfCounter
+	status   map[string]*daemonStatus
+	seen     map[string]time.Time
+	health   []cephHealthData
+}
+
+func newCephSnapshot() *cephSnapshot {
+	return &cephSnapshot{
+		devices:  make(map[string]map[string]string),
+		counters: make(map[string][]perfCounter),
+		status:   make(map[string]*daemonStatus),
+		seen:     make(map[string]time.Time),
+	}
+}
+
+// Return last complete snapshot. Empty snapshot is returned before first cycle finishes.
+func CurrentSnapshot() *cephSnapshot {
+	current, ok := snapshot.Load().(*cephSnapshot)
+	if !ok {
+		return newCephSnapshot()
+	}
+	return current
+}
+
+type socketResult struct {
+	socket   string
+	device   map[string]string
+	counters []perfCounter
+	duration float64
+	commands map[string]float64
+	finished time.Time
+	err      error
+	// Non fatal command errors, daemon is still up
+	failures []*socketError
+	// Process owning client socket
+	peer *socketPeer
+
+	schemaRefreshed bool
+}
+
 func Collector() {
-	var cephDeviceTmp map[string]string
 	log.Debug("Collector started")
-	sockets := ListCephSockets()
-	for _, socket := range sockets {
-		cephDeviceTmp = GetDeviceType(socket)
-		if cephDeviceTmp["type"] == "" {
-			log.Debug("Not a device. Skipping")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(*queryTimeout))
+	defer cancel()
+
+	var devices []string
+	for _, socket := range ListCephSockets() {
+		if GetDeviceType(socket)["type"] == "" {
+			log.Debug("Not a device. Skipping: ", socket)
+			continue
+		}
+		devices = append(devices, socket)
+	}
+
+	// Cluster health does not depend on local sockets, query it in parallel.
+	health := make(chan []cephHealthData, 1)
+	if *healthCollector {
+		go func() {
+			health <- CephHealthCollector(ctx)
+		}()
+	}
+
+	jobs := make(chan string)
+	results := make(chan socketResult)
+	var wg sync.WaitGroup
+	for i := 0; i < *queryWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for socket := range jobs {
+				results <- CollectSocket(ctx, socket)
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(jobs)
+		for i, socket := range devices {
+			select {
+			case jobs <- socket:
+			case <-ctx.Done():
+				// Cycle deadline reached. Report sockets which were not queried at all.
+				for _, socket := range devices[i:] {
+					results <- socketResult{socket: socket, device: GetDeviceType(socket), err: ctx.Err()}
+				}
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// Build new snapshot off to the side, scrapes keep using previous one meanwhile
+	previous := CurrentSnapshot()
+	next := newCephSnapshot()
+	for result := range results {
+		if result.device["daemon_type"] == "client" && FailureReason(result.err) == reasonConnectionRefused {
+			// Stale socket left by killed client process (e.g. VM), forget it like removed one
+			log.Debug("Client socket refuses connections, ignoring: ", result.socket)
 			continue
 		}
-		mutex.Lock()
-		cephDevice[socket] = cephDeviceTmp
-		osdSchema[socket] = (LoadJson(GetSchema(socket)))
-		cephMetrics[socket] = (LoadJson(GetMetrics(socket)))
-		if *healthCollector {
-			clusterHealth = CephHealthCollector()
+		next.seen[result.socket] = result.finished
+		next.status[result.socket] = newDaemonStatus(previous.status[result.socket], result)
+		if result.err != nil {
+			log.Warn("Failed to query socket ", result.socket, ": ", result.err)
+			continue
 		}
-		mutex.Unlock()
+		next.devices[result.socket] = result.device
+		next.counters[result.socket] = result.counters
 	}
+	ReconcileSockets(previous, next, time.Now())
+	if *healthCollector {
+		next.health = <-health
+		UpdateKnownPools(next.health)
+	}
+	snapshot.Store(next)
 	log.Debug("Collector stopped")
 }
 
+// Handle sockets which were present in previous snapshot, but are gone now.
+// During grace period last known data is carried over, afterwards daemon
+// is forgotten together with its schema, so recreated daemon gets fresh one.
+func ReconcileSockets(previous *cephSnapshot, next *cephSnapshot, now time.Time) {
+	grace := time.Second * time.Duration(*asokGrace)
+	for socket, seen := range previous.seen {
+		if _, ok := next.seen[socket]; ok {
+			continue
+		}
+		if now.Sub(seen) < grace {
+			log.Debug("Socket is gone, keeping it during grace period: ", socket)
+			next.seen[socket] = seen
+			next.status[socket] = previous.status[socket]
+			if counters, ok := previous.counters[socket]; ok {
+				next.devices[socket] = previous.devices[socket]
+				next.counters[socket] = counters
+			}
+			continue
+		}
+		log.Info("Socket is gone, removing its metrics: ", socket)
+	}
+	schemaMutex.Lock()
+	for socket := range schema {
+		if _, ok := next.seen[socket]; !ok {
+			delete(schema, socket)
+		}
+	}
+	schemaMutex.Unlock()
+}
+
+// Query schema and metrics of a single socket
+func CollectSocket(ctx context.Context, socket string) (result socketResult) {
+	start := time.Now()
+	result = socketResult{socket: socket, device: GetDeviceType(socket), commands: make(map[string]float64)}
+	defer func() {
+		result.finished = time.Now()
+		result.duration = result.finished.Sub(start).Seconds()
+	}()
+
+	commandStart := time.Now()
+	version, err := GetVersion(ctx, socket)
+	result.commands["version"] = time.Since(commandStart).Seconds()
+	if err != nil {
+		result.err = newSocketError("version", err)
+		return result
+	}
+
+	// Prefer labeled counters on daemons which support them
+	err = CollectCounterDump(ctx, socket, version, &result)
+	if errors.Is(err, errUnsupportedCommand) {
+		log.Debug("Labeled counters are not supported, using perf dump: ", socket)
+		err = CollectPerfDump(ctx, socket, version, &result)
+	}
+	if err != nil {
+		result.err = err
+		return result
+	}
+	var images []rbdImage
+	if HasRBDImages(result.counters) {
+		images = CollectRBDImages(ctx, socket, version, &result)
+	}
+	result.counters = ApplySectionRules(result.counters, images)
+
+	if *perfHistograms {
+		histograms, err := CollectHistograms(ctx, socket, version, result.commands)
+		if err != nil {
+			// Histograms are optional, daemon is still considered up
+			log.Warn("Failed to query histograms of socket ", socket, ": ", err)
+			result.failures = append(result.failures, err)
+		}
+		result.counters = DedupeCounters(append(result.counters, ApplySectionRules(histograms, images)...))
+	}
+	if result.device["daemon_type"] == "client" {
+		result.peer = GetPeer(ctx, socket)
+	}
+	return result
+}
+
+// Query perf schema and perf dump of a single socket
+func CollectPerfDump(ctx context.Context, socket string, version string, result *socketResult) error {
+	commandStart := time.Now()
+	socketSchema, refreshed, err := GetSchema(ctx, socket, "perf schema", version)
+	result.commands["perf schema"] = time.Since(commandStart).Seconds()
+	result.schemaRefreshed = result.schemaRefreshed || refreshed
+	var socketSchemaMap map[string]interface{}
+	if err == nil {
+		socketSchemaMap, err = LoadJson(socketSchema)
+	}
+	if err != nil {
+		DropSchema(socket)
+		return newSocketError("perf schema", err)
+	}
+
+	commandStart = time.Now()
+	socketMetrics, err := GetMetrics(ctx, socket, "perf dump")
+	result.commands["perf dump"] = time.Since(commandStart).Seconds()
+	var socketMetricsMap map[string]interface{}
+	if err == nil {
+		socketMetricsMap, err = LoadJson(socketMetrics)
+	}
+	if err == nil {
+		result.counters, err = ParsePerfCounters(socketSchemaMap, socketMetricsMap)
+		if errors.Is(err, errMissingSchema) {
+			// There's a possibility, that no full schema is yet available when ceph daemon
+			// is starting or new section (e.g. librbd image) was created since. Known counters
+			// are exported, schema is queried again during next cycle.
+			log.Debug("Missing schema for metric, - socket might be starting up: ", socket, ": ", err)
+			DropSchema(socket)
+			result.failures = append(result.failures, newSocketError("perf dump", err))
+			err = nil
+		}
+	}
+	if err != nil {
+		return newSocketError("perf dump", err)
+	}
+	return nil
+}
+
+// Derive context for a single ceph command from collection cycle context.
+func CommandContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, time.Second*time.Duration(*commandTimeout))
+}
+
 func (collector *cephCollector) Collect(ch chan<- prometheus.Metric) {
 	scrapeTime := time.Now()
 	log.Debug("Processing HTTP request")
-	mutex.RLock()
-	for socket, cephMetric := range cephMetrics {
-		for metricName, metricData := range cephMetric.(map[string]interface{}) {
-			for metricType, metricsValue := range metricData.(map[string]interface{}) {
-				metricSchema, ok := osdSchema[socket].(map[string]interface{})[metricName]
-				// There's a possibility, that no full schema is yet available when ceph daemon
-				// is starting. Thus we should check on that and destroy partial schema.
-				if !ok {
-					log.Debug("Missing schema for metric, - socket might be starting up: ", socket)
-					// Delete partial schema
-					delete(schema, socket)
-					continue
-				}
-				metric := metricSchema.(map[string]interface{})[metricType]
-				dataType := metric.(map[string]interface{})["type"].(float64)
-				metricDescription := metric.(map[string]interface{})["description"].(string)
-				normalizedMetricName := CephNormalizeMetricName(metricName)
-
-				// There are metrics with second level of data (SUMs and AVGs)
-				if reflect.TypeOf(metricsValue).Kind() == reflect.Map {
-					for metricType1, metricsValue1 := range metricsValue.(map[string]interface{}) {
-						description := CephPrometheusDesc(cephDevice[socket].(map[string]string)["type"]+"_"+normalizedMetricName+"_"+metricType+"_"+metricType1, metricDescription)
-						ch <- prometheus.MustNewConstMetric(description, GetDatatype(dataType), metricsValue1.(float64), cephDevice[socket].(map[string]string)["name"])
-					}
-				} else {
-					description := CephPrometheusDesc(cephDevice[socket].(map[string]string)["type"]+"_"+normalizedMetricName+"_"+metricType, metricDescription)
-					ch <- prometheus.MustNewConstMetric(description, GetDatatype(dataType), metricsValue.(float64), cephDevice[socket].(map[string]string)["name"])
-				}
-			}
-		}
+	current := CurrentSnapshot()
+	for socket, counters := range current.counters {
+		CollectPerfCounters(ch, current.devices[socket], counters)
 	}
-	for clusterHealthMetric, clusterHealthData := range clusterHealth {
-		description := CephPrometheusDesc(clusterHealthMetric, clusterHealthData.help)
-		ch <- prometheus.MustNewConstMetric(description, GetDatatype(clusterHealthData.metricType), clusterHealthData.value, "mon")
+	for _, clusterHealthData := range current.health {
+		labelNames, labelValues := clusterHealthData.LabelPairs()
+		description := ClusterPrometheusDesc(clusterHealthData.name, clusterHealthData.help, labelNames...)
+		labelValues = append([]string{ClusterName(), clusterHealthData.fsid}, labelValues...)
+		ch <- prometheus.MustNewConstMetric(description, GetDatatype(clusterHealthData.metricType), clusterHealthData.value, labelValues...)
 	}
-	mutex.RUnlock()
+	CollectDaemonStatus(ch, current)
 	description := prometheus.NewDesc("ceph_exporter_scrape_time", "Duration of a collector scrape", nil, nil)
 	ch <- prometheus.MustNewConstMetric(description, prometheus.GaugeValue, time.Since(scrapeTime).Seconds())
 	log.Debug("HTTP request finished")
@@ -175,50 +437,128 @@ func CephNormalizeMetricName(metric string) string {
 	return strings.ReplaceAll(metric, "__", "_")
 }
 
-func CephPrometheusDesc(metricName string, description string) *prometheus.Desc {
-	return prometheus.NewDesc(metricName, description, []string{"device"}, nil)
+func CephPrometheusDesc(metricName string, description string, labels ...string) *prometheus.Desc {
+	return prometheus.NewDesc(metricName, description, append(append([]string{}, daemonLabelNames...), labels...), nil)
+}
+
+// Description of cluster wide metric
+func ClusterPrometheusDesc(metricName string, description string, labels ...string) *prometheus.Desc {
+	return prometheus.NewDesc(metricName, description, append([]string{"cluster", "fsid"}, labels...), nil)
+}
+
+// Cluster name is derived from config file name, e.g. /etc/ceph/ceph.conf is cluster "ceph"
+func ClusterName() string {
+	return strings.TrimSuffix(filepath.Base(*cephConfigFile), ".conf")
 }
 
 // Get schema for defined socket. Either query ceph or use stored map if exists.
-func GetSchema(socket string) string {
+// Command is schema command, e.g. "perf schema" or "perf histogram schema".
+// Second return value reports whether previously stored schema had to be refreshed.
+func GetSchema(ctx context.Context, socket string, command string, version string) (string, bool, error) {
 	log.Debug("Searching inmemory schema for: ", socket)
-	if len(schema[socket]) > 0 {
+	inode, mtime, err := SocketIdentity(socket)
+	if err != nil {
+		return "", false, err
+	}
+	refresh := false
+	schemaMutex.Lock()
+	cached := schema[socket]
+	if cached == nil || cached.stale || cached.inode != inode || !cached.mtime.Equal(mtime) || cached.version != version {
+		if cached != nil {
+			log.Info("Daemon restarted, upgraded or schema is incomplete. Refreshing schema: ", socket)
+			refresh = true
+		}
+		cached = &schemaCacheEntry{schemas: make(map[string]string), unsupported: make(map[string]bool), inode: inode, mtime: mtime, version: version}
+		schema[socket] = cached
+	}
+	socketSchema, ok := cached.schemas[command]
+	unsupported := cached.unsupported[command]
+	schemaMutex.Unlock()
+	if unsupported {
+		return "", refresh, errUnsupportedCommand
+	}
+	if ok {
 		log.Debug("Inmemory schema found")
-	} else {
-		log.Debug("Inmemory schema missing. Generating schema.")
-		cmdOutput, _ := exec.Command("ceph", "--admin-daemon", socket, "perf", "schema").Output()
-		schema[socket] = string(cmdOutput)
+		return socketSchema, refresh, nil
+	}
+
+	log.Debug("Inmemory schema missing. Generating schema.")
+	ctx, cancel := CommandContext(ctx)
+	defer cancel()
+	cmdOutput, err := AsokCommand(ctx, socket, command)
+	if err != nil {
+		return "", refresh, err
 	}
-	return schema[socket]
+	schemaMutex.Lock()
+	cached.schemas[command] = string(cmdOutput)
+	schemaMutex.Unlock()
+	return string(cmdOutput), refresh, nil
 }
 
-// Get metrics from defined socket
-func GetMetrics(socket string) string {
-	log.Debug("Getting metrics for ", socket)
-	cmdOutput, _ := exec.Command("ceph", "--admin-daemon", socket, "perf", "dump").Output()
-	return string(cmdOutput)
+// Remember that daemon does not support schema command until it's restarted or upgraded
+func MarkUnsupported(socket string, command string) {
+	schemaMutex.Lock()
+	if cached, ok := schema[socket]; ok {
+		cached.unsupported[command] = true
+	}
+	schemaMutex.Unlock()
 }
 
-// Get a list of ceph admin sockets
-func ListCephSockets() []string {
-	log.Debug("Getting ceph asok list")
-	sockets, err := filepath.Glob(*asokPath + "/*.asok")
+// Mark stored schema as stale, so it's queried again during next cycle
+func DropSchema(socket string) {
+	schemaMutex.Lock()
+	if cached, ok := schema[socket]; ok {
+		cached.stale = true
+	}
+	schemaMutex.Unlock()
+}
+
+// Get daemon version from defined socket
+func GetVersion(ctx context.Context, socket string) (string, error) {
+	ctx, cancel := CommandContext(ctx)
+	defer cancel()
+	cmdOutput, err := AsokCommand(ctx, socket, "version")
+	if err != nil {
+		return "", err
+	}
+	var version struct {
+		Version string `json:"version"`
+	}
+	if err := json.Unmarshal(cmdOutput, &version); err != nil || version.Version == "" {
+		return string(cmdOutput), nil
+	}
+	return version.Version, nil
+}
+
+// Return inode and modification time of socket file.
+// Both change when daemon is restarted and recreates its socket.
+func SocketIdentity(socket string) (uint64, time.Time, error) {
+	info, err := os.Stat(socket)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	var inode uint64
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		inode = uint64(stat.Ino)
+	}
+	return inode, info.ModTime(), nil
+}
+
+// Get metrics from defined socket, e.g. "perf dump" or "perf histogram dump"
+func GetMetrics(ctx context.Context, socket string, command string) (string, error) {
+	log.Debug("Getting metrics for ", socket)
+	ctx, cancel := CommandContext(ctx)
+	defer cancel()
+	cmdOutput, err := AsokCommand(ctx, socket, command)
 	if err != nil {
-		log.Fatal(err)
+		return "", err
 	}
-	return sockets
+	return string(cmdOutput), nil
 }
 
 // Return correct datatype for metric.
+// Type is decoded as perf counter type bitmask, only COUNTER bit makes it a counter.
 // https://docs.ceph.com/docs/master/dev/perf_counters/
 func GetDatatype(dataType float64) prometheus.ValueType {
-	switch dataType {
-	case 2:
-		return prometheus.GaugeValue
-	case 5:
-		return prometheus.GaugeValue
-	case 10:
-		return prometheus.CounterValue
-	}
-	return prometheus.GaugeValue
+	return perfCounterType(dataType).ValueType()
 }
@@ -1,9 +1,12 @@
 package main
 
 import "testing"
+import "time"
+import "io/ioutil"
+import "net"
+import "context"
 import "os"
-import "fmt"
-import "regexp"
+import "path/filepath"
 import "github.com/prometheus/client_golang/prometheus"
 
 func TestGetDatatype(t *testing.T) {
@@ -33,41 +36,200 @@ func TestCephNormalizeMetricName(t *testing.T) {
 }
 
 func TestGetDeviceType(t *testing.T) {
-	value := GetDeviceType("/var/run/ceph/ceph-cluster-osd.1.asok")
-	if value["type"] != "ceph_osd" || value["name"] != "osd1" {
-		t.Errorf("GetDeviceType failed. Got: type: %s, name: %s", value["type"], value["name"])
+	cases := []struct {
+		socket     string
+		metricType string
+		cluster    string
+		daemon     string
+		daemonType string
+		fsid       string
+	}{
+		// ceph-deploy and packages
+		{"/var/run/ceph/ceph-osd.12.asok", "ceph_osd", "ceph", "osd.12", "osd", ""},
+		{"/var/run/ceph/ceph-mon.test-ceph-mon1.asok", "ceph_monitor", "ceph", "mon.test-ceph-mon1", "mon", ""},
+		{"/var/run/ceph/ceph-mgr.a.asok", "ceph_mgr", "ceph", "mgr.a", "mgr", ""},
+		{"/var/run/ceph/ceph-client.radosgw.test-ceph-osd1.asok", "ceph_radosgw", "ceph", "client.radosgw.test-ceph-osd1", "rgw", ""},
+		{"/var/run/ceph/ceph-client.rgw.zone1.host.asok", "ceph_radosgw", "ceph", "client.rgw.zone1.host", "rgw", ""},
+		{"/var/run/ceph/ceph-cluster-osd.1.asok", "ceph_osd", "ceph-cluster", "osd.1", "osd", ""},
+		{"/var/run/ceph/backup-mon.a.asok", "ceph_monitor", "backup", "mon.a", "mon", ""},
+		{"/var/run/ceph/ceph-client.admin.asok", "ceph_client", "ceph", "client.admin", "client", ""},
+		{"/var/run/ceph/ceph-client.libvirt.4242.94251234567890.asok", "ceph_client", "ceph", "client.libvirt.4242.94251234567890", "client", ""},
+		{"/var/run/ceph/guests/ceph-client.cinder.4243.94251234567891.asok", "ceph_client", "ceph", "client.cinder.4243.94251234567891", "client", ""},
+		{"/var/run/ceph/ceph-mds.fs1.host1.asok", "ceph_mds", "ceph", "mds.fs1.host1", "mds", ""},
+		{"/var/run/ceph/ceph-client.rbd-mirror.host1.asok", "ceph_rbd_mirror", "ceph", "client.rbd-mirror.host1", "rbd-mirror", ""},
+		{"/var/run/ceph/ceph-client.cephfs-mirror.host1.asok", "ceph_cephfs_mirror", "ceph", "client.cephfs-mirror.host1", "cephfs-mirror", ""},
+		{"/var/run/ceph/ceph-foo.a.asok", "ceph_foo", "ceph", "foo.a", "foo", ""},
+		// cephadm
+		{"/var/run/ceph/0b3a1b0c-5f7e-11ee-8c99-0242ac120002/ceph-osd.3.asok", "ceph_osd", "ceph", "osd.3", "osd", "0b3a1b0c-5f7e-11ee-8c99-0242ac120002"},
+		{"/var/run/ceph/0b3a1b0c-5f7e-11ee-8c99-0242ac120002/ceph-client.rgw.default.host1.abcdef.asok", "ceph_radosgw", "ceph", "client.rgw.default.host1.abcdef", "rgw", "0b3a1b0c-5f7e-11ee-8c99-0242ac120002"},
+		// Rook
+		{"/var/lib/rook/exporter/ceph-mon.b.asok", "ceph_monitor", "ceph", "mon.b", "mon", ""},
+		{"/var/lib/rook/exporter/rook-ceph-osd.0.asok", "ceph_osd", "rook-ceph", "osd.0", "osd", ""},
+		{"/var/lib/rook/exporter/ceph-client.rgw.my.store.a.asok", "ceph_radosgw", "ceph", "client.rgw.my.store.a", "rgw", ""},
 	}
-	value = GetDeviceType("/var/run/ceph/ceph-cluster-mon.test-ceph-mon1.asok")
-	if value["type"] != "ceph_monitor" || value["name"] != "mon" {
-		t.Errorf("GetDeviceType failed. Got: type: %s, name: %s", value["type"], value["name"])
-	}
-	value = GetDeviceType("/var/run/ceph/ceph-cluster-client.radosgw.test-ceph-osd1.asok")
-	if value["type"] != "ceph_radosgw" || value["name"] != "radosgw" {
-		t.Errorf("GetDeviceType failed. Got: type: %s, name: %s", value["type"], value["name"])
-	}
-	value = GetDeviceType("/var/run/ceph/ceph-cluster-client.radosgw.test-ceph-mgr.asok")
-	if value["type"] != "ceph_mgr" || value["name"] != "mgr" {
-		t.Errorf("GetDeviceType failed. Got: type: %s, name: %s", value["type"], value["name"])
+	for _, c := range cases {
+		value := GetDeviceType(c.socket)
+		if value["type"] != c.metricType || value["cluster"] != c.cluster || value["ceph_daemon"] != c.daemon ||
+			value["daemon_type"] != c.daemonType || value["fsid"] != c.fsid {
+			t.Errorf("GetDeviceType(%s) failed. Got: %v", c.socket, value)
+		}
+		if value["hostname"] != hostname {
+			t.Errorf("GetDeviceType(%s) should set hostname. Got: %s", c.socket, value["hostname"])
+		}
 	}
 }
 
 func TestLoadJson(t *testing.T) {
-	schema := string(`{
-      "client.radosgw.%s": {
-        "req": {
-          "type": 10,
-          "description": "Requests",
-          "nick": ""
-        }
-      }
-    }`)
-	hostname, _ := os.Hostname()
-	re := regexp.MustCompile(`^([^.]+)`)
-	hostname = re.FindString(hostname)
-	schema = fmt.Sprintf(schema, hostname)
-	schemaMap := LoadJson(schema)
-	result := schemaMap["client.radosgw"].(map[string]interface{})["req"].(map[string]interface{})["type"].(float64)
+	schemaMap, err := LoadJson(`{"osd": {"op": {"type": 10, "description": "Client operations", "nick": ""}}}`)
+	if err != nil {
+		t.Fatalf("LoadJson failed: %v", err)
+	}
+	result := schemaMap["osd"].(map[string]interface{})["op"].(map[string]interface{})["type"].(float64)
 	if int(result) != 10 {
 		t.Errorf("LoadJson failed. Got: %v, needed: 10", result)
 	}
 }
+
+func TestCollectorSnapshot(t *testing.T) {
+	socket, stop := fakeAsokServer(t, map[string]string{
+		"version":     `{"version":"17.2.6","release":"quincy","release_type":"stable"}`,
+		"perf schema": `{"osd":{"op":{"type":10,"description":"Client operations"}}}`,
+		"perf dump":   `{"osd":{"op":42}}`,
+	})
+	defer stop()
+	*asokPath = filepath.Dir(socket)
+
+	previous := CurrentSnapshot()
+	Collector()
+	current := CurrentSnapshot()
+	if current == previous {
+		t.Fatalf("Collector did not store new snapshot")
+	}
+	if current.devices[socket]["ceph_daemon"] != "osd.0" {
+		t.Errorf("Snapshot has wrong device: %v", current.devices[socket])
+	}
+	counters := current.counters[socket]
+	if len(counters) != 1 || counters[0].value != 42 {
+		t.Fatalf("Snapshot has wrong counters: %v", counters)
+	}
+}
+
+func TestCollectorDaemonStatus(t *testing.T) {
+	socket, stop := fakeAsokServer(t, map[string]string{
+		"version":     `{"version":"17.2.6","release":"quincy","release_type":"stable"}`,
+		"perf schema": `{"osd":{"op":{"type":10,"description":"Client operations"}}}`,
+		"perf dump":   `{"osd":{"op":42,"op_r":1}}`,
+	})
+	defer stop()
+	*asokPath = filepath.Dir(socket)
+
+	Collector()
+	status := CurrentSnapshot().status[socket]
+	if status == nil || !status.up {
+		t.Fatalf("Daemon with incomplete schema should stay up. Got: %v", status)
+	}
+	if status.errors[commandFailure{command: "perf dump", reason: reasonMissingSchema}] != 1 {
+		t.Errorf("Missing schema error not counted. Got: %v", status.errors)
+	}
+	if counters := CurrentSnapshot().counters[socket]; len(counters) != 1 || counters[0].name != "op" {
+		t.Errorf("Counters with schema should be exported. Got: %v", counters)
+	}
+	Collector()
+	status = CurrentSnapshot().status[socket]
+	if status.errors[commandFailure{command: "perf dump", reason: reasonMissingSchema}] != 2 {
+		t.Errorf("Error counter should be carried over. Got: %v", status.errors)
+	}
+	if status.refreshes != 1 {
+		t.Errorf("Incomplete schema should be queried again. Got: %v refreshes", status.refreshes)
+	}
+}
+
+func TestCollectorStaleClientSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ceph-exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// Killed client leaves its socket file behind
+	socket := filepath.Join(dir, "ceph-client.libvirt.4242.94251234567890.asok")
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: socket, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.SetUnlinkOnClose(false)
+	listener.Close()
+	*asokPath = dir
+
+	Collector()
+	if _, ok := CurrentSnapshot().status[socket]; ok {
+		t.Errorf("Stale client socket should be ignored")
+	}
+}
+
+func TestFailureReason(t *testing.T) {
+	_, err := LoadJson("not json")
+	if reason := FailureReason(err); reason != reasonBadJson {
+		t.Errorf("Bad json reason expected. Got: %s", reason)
+	}
+	if reason := FailureReason(context.DeadlineExceeded); reason != reasonTimeout {
+		t.Errorf("Timeout reason expected. Got: %s", reason)
+	}
+	_, err = AsokCommand(context.Background(), "/nonexistent/ceph-osd.0.asok", "perf dump")
+	if reason := FailureReason(err); reason != reasonError {
+		t.Errorf("Generic error reason expected. Got: %s", reason)
+	}
+}
+
+func TestReconcileSockets(t *testing.T) {
+	now := time.Now()
+	previous := newCephSnapshot()
+	for _, socket := range []string{"ceph-osd.1.asok", "ceph-osd.2.asok"} {
+		previous.seen[socket] = now.Add(-10 * time.Second)
+		previous.devices[socket] = GetDeviceType(socket)
+		previous.counters[socket] = []perfCounter{}
+		schema[socket] = &schemaCacheEntry{schemas: map[string]string{"perf schema": "{}"}, unsupported: map[string]bool{}}
+	}
+	previous.seen["ceph-osd.1.asok"] = now.Add(-time.Second)
+
+	*asokGrace = 5
+	next := newCephSnapshot()
+	ReconcileSockets(previous, next, now)
+	if _, ok := next.counters["ceph-osd.1.asok"]; !ok {
+		t.Errorf("Socket within grace period should be kept")
+	}
+	if _, ok := next.counters["ceph-osd.2.asok"]; ok {
+		t.Errorf("Socket after grace period should be removed")
+	}
+	if _, ok := schema["ceph-osd.2.asok"]; ok {
+		t.Errorf("Schema of removed socket should be dropped")
+	}
+
+	*asokGrace = 0
+	next = newCephSnapshot()
+	ReconcileSockets(previous, next, now)
+	if len(next.counters) != 0 || len(schema) != 0 {
+		t.Errorf("Without grace period all gone sockets should be removed")
+	}
+}
+
+func TestGetSchemaRefresh(t *testing.T) {
+	socket, stop := fakeAsokServer(t, map[string]string{
+		"perf schema": `{"osd":{"op":{"type":10,"description":"Client operations"}}}`,
+	})
+	defer stop()
+	ctx := context.Background()
+
+	if _, refreshed, err := GetSchema(ctx, socket, "perf schema", "16.2.10"); err != nil || refreshed {
+		t.Errorf("First schema fetch should not be a refresh. Got: %v, %v", refreshed, err)
+	}
+	if _, refreshed, _ := GetSchema(ctx, socket, "perf schema", "16.2.10"); refreshed {
+		t.Errorf("Schema should be served from cache")
+	}
+	if _, refreshed, _ := GetSchema(ctx, socket, "perf schema", "17.2.6"); !refreshed {
+		t.Errorf("Schema should be refreshed after version change")
+	}
+	DropSchema(socket)
+	if _, refreshed, _ := GetSchema(ctx, socket, "perf schema", "17.2.6"); !refreshed {
+		t.Errorf("Schema should be refreshed after it was dropped")
+	}
+}
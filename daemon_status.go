@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"net"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Failure reasons exported in "reason" label of ceph_exporter_daemon_errors_total
+const (
+	reasonTimeout           = "timeout"
+	reasonConnectionRefused = "connection_refused"
+	reasonBadJson           = "bad_json"
+	reasonMissingSchema     = "missing_schema"
+	reasonError             = "error"
+)
+
+var errMissingSchema = errors.New("metric missing in schema")
+
+// Error of a single admin socket command together with classified failure reason
+type socketError struct {
+	command string
+	reason  string
+	err     error
+}
+
+func newSocketError(command string, err error) *socketError {
+	return &socketError{command: command, reason: FailureReason(err), err: err}
+}
+
+func (e *socketError) Error() string {
+	return e.command + " (" + e.reason + "): " + e.err.Error()
+}
+
+func (e *socketError) Unwrap() error {
+	return e.err
+}
+
+// Classify error into one of failure reasons
+func FailureReason(err error) string {
+	var netErr net.Error
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return reasonTimeout
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return reasonTimeout
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return reasonConnectionRefused
+	case errors.Is(err, errMissingSchema):
+		return reasonMissingSchema
+	case errors.As(err, &syntaxErr), errors.As(err, &typeErr):
+		return reasonBadJson
+	}
+	return reasonError
+}
+
+type commandFailure struct {
+	command string
+	reason  string
+}
+
+// Collection status of a single daemon socket.
+// Error counters are carried over from previous snapshot, so they only grow.
+type daemonStatus struct {
+	device      map[string]string
+	up          bool
+	lastSuccess time.Time
+	duration    float64
+	commands    map[string]float64
+	errors      map[commandFailure]float64
+	refreshes   float64
+	peer        *socketPeer
+}
+
+// Build status for current cycle on top of status from previous cycle
+func newDaemonStatus(previous *daemonStatus, result socketResult) *daemonStatus {
+	status := &daemonStatus{
+		device:   result.device,
+		up:       result.err == nil,
+		duration: result.duration,
+		commands: result.commands,
+		errors:   make(map[commandFailure]float64),
+		peer:     result.peer,
+	}
+	if previous != nil {
+		status.lastSuccess = previous.lastSuccess
+		status.refreshes = previous.refreshes
+		if status.peer == nil {
+			status.peer = previous.peer
+		}
+		for failure, count := range previous.errors {
+			status.errors[failure] = count
+		}
+	}
+	for _, failure := range result.failures {
+		status.errors[commandFailure{command: failure.command, reason: failure.reason}]++
+	}
+	var socketErr *socketError
+	if errors.As(result.err, &socketErr) {
+		status.errors[commandFailure{command: socketErr.command, reason: socketErr.reason}]++
+	} else if result.err != nil {
+		status.errors[commandFailure{command: "none", reason: FailureReason(result.err)}]++
+	}
+	if result.schemaRefreshed {
+		status.refreshes++
+	}
+	if status.up {
+		status.lastSuccess = result.finished
+	}
+	return status
+}
+
+func daemonStatusDesc(metricName string, description string, labels ...string) *prometheus.Desc {
+	return prometheus.NewDesc(metricName, description, append(append([]string{}, daemonLabelNames...), labels...), nil)
+}
+
+var (
+	daemonUpDesc          = daemonStatusDesc("ceph_exporter_daemon_up", "Was last query of daemon admin socket successful (1:yes, 0:no)")
+	daemonLastSuccessDesc = daemonStatusDesc("ceph_exporter_daemon_last_success_timestamp_seconds", "Timestamp of last successful daemon admin socket query")
+	daemonDurationDesc    = daemonStatusDesc("ceph_exporter_daemon_collection_duration_seconds", "Duration of last daemon admin socket query")
+	daemonCommandDesc     = daemonStatusDesc("ceph_exporter_daemon_command_duration_seconds", "Duration of last admin socket command", "command")
+	daemonErrorsDesc      = daemonStatusDesc("ceph_exporter_daemon_errors_total", "Number of failed admin socket commands", "command", "reason")
+	schemaRefreshesDesc   = daemonStatusDesc("ceph_exporter_schema_refreshes_total", "Number of times daemon schema was refreshed after restart, upgrade or incomplete schema")
+	daemonProcessDesc     = daemonStatusDesc("ceph_exporter_daemon_process_info", "Process owning client admin socket, vm is qemu guest name", "pid", "process", "vm")
+)
+
+// Export collection status of every known daemon socket
+func CollectDaemonStatus(ch chan<- prometheus.Metric, current *cephSnapshot) {
+	for _, status := range current.status {
+		labels := DaemonLabelValues(status.device)
+		up := 0.0
+		if status.up {
+			up = 1
+		}
+		ch <- prometheus.MustNewConstMetric(daemonUpDesc, prometheus.GaugeValue, up, labels...)
+		if !status.lastSuccess.IsZero() {
+			ch <- prometheus.MustNewConstMetric(daemonLastSuccessDesc, prometheus.GaugeValue, float64(status.lastSuccess.UnixNano())/1e9, labels...)
+		}
+		ch <- prometheus.MustNewConstMetric(daemonDurationDesc, prometheus.GaugeValue, status.duration, labels...)
+		ch <- prometheus.MustNewConstMetric(schemaRefreshesDesc, prometheus.CounterValue, status.refreshes, labels...)
+		if status.peer != nil {
+			ch <- prometheus.MustNewConstMetric(daemonProcessDesc, prometheus.GaugeValue, 1, append(labels, strconv.Itoa(status.peer.pid), status.peer.process, status.peer.vm)...)
+		}
+		for command, duration := range status.commands {
+			ch <- prometheus.MustNewConstMetric(daemonCommandDesc, prometheus.GaugeValue, duration, append(labels, command)...)
+		}
+		for failure, count := range status.errors {
+			ch <- prometheus.MustNewConstMetric(daemonErrorsDesc, prometheus.CounterValue, count, append(labels, failure.command, failure.reason)...)
+		}
+	}
+}
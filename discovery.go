@@ -0,0 +1,103 @@
+package main
+
+import (
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Socket layouts searched in every configured directory:
+// flat directory (packages, ceph-deploy, Rook exporter directory),
+// cephadm per cluster directory /var/run/ceph/<fsid>/ and Rook per daemon directories.
+var socketLayouts = []string{"*.asok", "*/*.asok"}
+
+var fsidRegexp = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// Split comma separated asok.path flag into directories and glob patterns
+func SocketPaths() []string {
+	var paths []string
+	for _, path := range strings.Split(*asokPath, ",") {
+		path = strings.TrimSpace(path)
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+func isGlobPattern(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// Get a list of ceph admin sockets
+func ListCephSockets() []string {
+	log.Debug("Getting ceph asok list")
+	found := make(map[string]bool)
+	for _, path := range SocketPaths() {
+		patterns := []string{path}
+		if !isGlobPattern(path) {
+			patterns = nil
+			for _, layout := range socketLayouts {
+				patterns = append(patterns, filepath.Join(path, layout))
+			}
+		}
+		for _, pattern := range patterns {
+			sockets, err := filepath.Glob(pattern)
+			if err != nil {
+				log.Error("Bad admin socket pattern ", pattern, ": ", err)
+				continue
+			}
+			for _, socket := range sockets {
+				found[socket] = true
+			}
+		}
+	}
+	sockets := make([]string, 0, len(found))
+	for socket := range found {
+		sockets = append(sockets, socket)
+	}
+	sort.Strings(sockets)
+	return sockets
+}
+
+// Directories which should be watched for socket changes
+func SocketDirs() []string {
+	found := make(map[string]bool)
+	for _, path := range SocketPaths() {
+		if isGlobPattern(path) {
+			// Watch directories of currently matching sockets,
+			// new directories are picked up by periodic rescan.
+			matches, _ := filepath.Glob(path)
+			for _, match := range matches {
+				found[filepath.Dir(match)] = true
+			}
+			continue
+		}
+		found[path] = true
+		subdirs, _ := filepath.Glob(filepath.Join(path, "*"))
+		for _, subdir := range subdirs {
+			if info, err := os.Stat(subdir); err == nil && info.IsDir() {
+				found[subdir] = true
+			}
+		}
+	}
+	dirs := make([]string, 0, len(found))
+	for dir := range found {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// Derive cluster fsid from socket path, e.g. cephadm /var/run/ceph/<fsid>/ceph-osd.0.asok
+func SocketFsid(socket string) string {
+	for dir := filepath.Dir(socket); dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		if fsidRegexp.MatchString(filepath.Base(dir)) {
+			return filepath.Base(dir)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestListCephSockets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ceph-exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fsid := "b3d2c8a4-1f7e-11ee-9d4b-525400a1b2c3"
+	files := []string{
+		"run/ceph-osd.0.asok",
+		"run/" + fsid + "/ceph-osd.1.asok",
+		"run/" + fsid + "/ceph.conf",
+		"rook/mon-a/ceph-mon.a.asok",
+		"other/ceph-client.rgw.zone1.asok",
+	}
+	for _, file := range files {
+		path := filepath.Join(dir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	*asokPath = filepath.Join(dir, "run") + ", " + filepath.Join(dir, "rook") + "," + filepath.Join(dir, "other", "*rgw*.asok")
+	sockets := ListCephSockets()
+	expected := []string{
+		filepath.Join(dir, "other/ceph-client.rgw.zone1.asok"),
+		filepath.Join(dir, "rook/mon-a/ceph-mon.a.asok"),
+		filepath.Join(dir, "run/"+fsid+"/ceph-osd.1.asok"),
+		filepath.Join(dir, "run/ceph-osd.0.asok"),
+	}
+	if !reflect.DeepEqual(sockets, expected) {
+		t.Errorf("ListCephSockets failed. Got: %v, needed: %v", sockets, expected)
+	}
+	if value := SocketFsid(expected[2]); value != fsid {
+		t.Errorf("SocketFsid failed. Got: %s, needed: %s", value, fsid)
+	}
+	if value := SocketFsid(expected[3]); value != "" {
+		t.Errorf("SocketFsid should be empty for flat layout. Got: %s", value)
+	}
+}
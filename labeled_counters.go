@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"regexp"
+	"sort"
+	"time"
+)
+
+var errUnsupportedCommand = errors.New("command is not supported by daemon")
+
+var invalidLabelRegexp = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// Single label set of a section in `counter schema` and `counter dump` output.
+// Available since Reef, e.g. {"rgw_op": [{"labels": {"Bucket": "b1"}, "counters": {"put_obj_ops": 1}}]}
+type labeledCounters struct {
+	Labels   map[string]string      `json:"labels"`
+	Counters map[string]interface{} `json:"counters"`
+}
+
+// Query counter schema and counter dump of a single socket.
+// errUnsupportedCommand is returned when daemon does not support labeled counters.
+func CollectCounterDump(ctx context.Context, socket string, version string, result *socketResult) error {
+	commandStart := time.Now()
+	counterSchema, refreshed, err := GetSchema(ctx, socket, "counter schema", version)
+	result.schemaRefreshed = result.schemaRefreshed || refreshed
+	if errors.Is(err, errUnsupportedCommand) {
+		return err
+	}
+	result.commands["counter schema"] = time.Since(commandStart).Seconds()
+	var counterSchemaMap map[string][]labeledCounters
+	if err == nil {
+		err = json.Unmarshal([]byte(counterSchema), &counterSchemaMap)
+	}
+	if err != nil {
+		reason := FailureReason(err)
+		if reason == reasonTimeout || reason == reasonConnectionRefused {
+			return newSocketError("counter schema", err)
+		}
+		// Older daemons reply with error message or close connection on unknown command
+		log.Debug("counter schema failed, assuming labeled counters are not supported: ", err)
+		MarkUnsupported(socket, "counter schema")
+		delete(result.commands, "counter schema")
+		return errUnsupportedCommand
+	}
+
+	commandStart = time.Now()
+	counterDump, err := GetMetrics(ctx, socket, "counter dump")
+	result.commands["counter dump"] = time.Since(commandStart).Seconds()
+	var counterDumpMap map[string][]labeledCounters
+	if err == nil {
+		err = json.Unmarshal([]byte(counterDump), &counterDumpMap)
+	}
+	if err == nil {
+		result.counters, err = ParseLabeledCounters(counterSchemaMap, counterDumpMap)
+		if errors.Is(err, errMissingSchema) {
+			log.Debug("Missing schema for metric, - socket might be starting up: ", socket, ": ", err)
+			DropSchema(socket)
+			result.failures = append(result.failures, newSocketError("counter dump", err))
+			err = nil
+		}
+	}
+	if err != nil {
+		return newSocketError("counter dump", err)
+	}
+	return nil
+}
+
+// Decode counter dump using counter schema. Ceph labels become counter labels.
+// Like in ParsePerfCounters, counters missing in schema are skipped and reported as errMissingSchema.
+func ParseLabeledCounters(schema map[string][]labeledCounters, dump map[string][]labeledCounters) ([]perfCounter, error) {
+	var counters []perfCounter
+	var missingErr error
+	for section, labelSets := range dump {
+		// Counters are the same for every label set of a section
+		sectionSchema := make(map[string]interface{})
+		for _, labelSet := range schema[section] {
+			for name, counterSchema := range labelSet.Counters {
+				sectionSchema[name] = counterSchema
+			}
+		}
+		for _, labelSet := range labelSets {
+			parsed, err := ParsePerfCounters(
+				map[string]interface{}{section: sectionSchema},
+				map[string]interface{}{section: labelSet.Counters},
+			)
+			if errors.Is(err, errMissingSchema) {
+				missingErr = err
+			} else if err != nil {
+				return nil, err
+			}
+			labels := make(map[string]string, len(labelSet.Labels))
+			for name, value := range labelSet.Labels {
+				labels[CephLabelName(name)] = value
+			}
+			for i := range parsed {
+				parsed[i].labels = labels
+			}
+			counters = append(counters, parsed...)
+		}
+	}
+	return FillLabelSets(counters), missingErr
+}
+
+// Convert ceph label to valid prometheus label name not clashing with exporter labels
+func CephLabelName(name string) string {
+	name = invalidLabelRegexp.ReplaceAllString(name, "_")
+	for _, daemonLabel := range daemonLabelNames {
+		if name == daemonLabel {
+			return "ceph_" + name
+		}
+	}
+	return name
+}
+
+// Make every counter of the same metric carry the same label names,
+// missing labels are set to empty value.
+func FillLabelSets(counters []perfCounter) []perfCounter {
+	labelNames := make(map[string]map[string]bool)
+	for _, counter := range counters {
+		key := counter.section + "." + counter.name
+		if labelNames[key] == nil {
+			labelNames[key] = make(map[string]bool)
+		}
+		for name := range counter.labels {
+			labelNames[key][name] = true
+		}
+	}
+	for i, counter := range counters {
+		names := labelNames[counter.section+"."+counter.name]
+		if len(names) == len(counter.labels) {
+			continue
+		}
+		labels := make(map[string]string, len(names))
+		for name := range names {
+			labels[name] = counter.labels[name]
+		}
+		counters[i].labels = labels
+	}
+	return counters
+}
+
+// Sorted label names and matching values of a counter
+func (counter perfCounter) LabelPairs() ([]string, []string) {
+	names := make([]string, 0, len(counter.labels))
+	for name := range counter.labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	values := make([]string, 0, len(names))
+	for _, name := range names {
+		values = append(values, counter.labels[name])
+	}
+	return names, values
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestCollectSocketLabeledCounters(t *testing.T) {
+	socket, stop := fakeAsokServer(t, map[string]string{
+		"version": `{"version":"18.2.0","release":"reef","release_type":"stable"}`,
+		"counter schema": `{"rgw_op": [
+			{"labels": {"Bucket": "b1"}, "counters": {"put_obj_ops": {"type": 10, "description": "Puts"}}},
+			{"labels": {"User": "u1"}, "counters": {"put_obj_ops": {"type": 10, "description": "Puts"}}}
+		]}`,
+		"counter dump": `{"rgw_op": [
+			{"labels": {"Bucket": "b1"}, "counters": {"put_obj_ops": 3}},
+			{"labels": {"User": "u1"}, "counters": {"put_obj_ops": 5}}
+		]}`,
+	})
+	defer stop()
+
+	result := CollectSocket(context.Background(), socket)
+	if result.err != nil {
+		t.Fatalf("CollectSocket failed: %v", result.err)
+	}
+	if _, ok := result.commands["perf dump"]; ok {
+		t.Errorf("perf dump should not be used when labeled counters are supported")
+	}
+	labels := make(map[float64]map[string]string)
+	for _, counter := range result.counters {
+		labels[counter.value] = counter.labels
+	}
+	expected := map[float64]map[string]string{
+		3: {"Bucket": "b1", "User": ""},
+		5: {"Bucket": "", "User": "u1"},
+	}
+	if !reflect.DeepEqual(labels, expected) {
+		t.Errorf("Labeled counters are wrong. Got: %v, needed: %v", labels, expected)
+	}
+}
+
+func TestCollectSocketLabeledCountersFallback(t *testing.T) {
+	socket, stop := fakeAsokServer(t, map[string]string{
+		"version":     `{"version":"16.2.10","release":"pacific","release_type":"stable"}`,
+		"perf schema": `{"osd":{"op":{"type":10,"description":"Client operations"}}}`,
+		"perf dump":   `{"osd":{"op":42}}`,
+	})
+	defer stop()
+
+	for i := 0; i < 2; i++ {
+		result := CollectSocket(context.Background(), socket)
+		if result.err != nil || len(result.counters) != 1 || result.counters[0].value != 42 {
+			t.Fatalf("CollectSocket should fall back to perf dump. Got: %v, %v", result.counters, result.err)
+		}
+		if _, ok := result.commands["counter schema"]; ok {
+			t.Errorf("Unsupported counter schema should not be reported as command")
+		}
+	}
+}
+
+func TestParseLabeledCountersMissingSchema(t *testing.T) {
+	schema := map[string][]labeledCounters{"rgw_op": {
+		{Labels: map[string]string{"Bucket": "b1"}, Counters: map[string]interface{}{"put_obj_ops": map[string]interface{}{"type": 10.0}}},
+	}}
+	dump := map[string][]labeledCounters{
+		"rgw_op":    {{Labels: map[string]string{"Bucket": "b1"}, Counters: map[string]interface{}{"put_obj_ops": 3.0}}},
+		"rgw_cache": {{Labels: map[string]string{"Bucket": "b1"}, Counters: map[string]interface{}{"hits": 1.0}}},
+	}
+	counters, err := ParseLabeledCounters(schema, dump)
+	if !errors.Is(err, errMissingSchema) {
+		t.Errorf("Missing schema error expected. Got: %v", err)
+	}
+	if len(counters) != 1 || counters[0].value != 3 {
+		t.Errorf("Counters with schema should still be decoded. Got: %v", counters)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Every image opened by librbd registers `rbd cache flush <pool>/<image>` admin socket command
+const rbdCacheFlushCommand = "rbd cache flush "
+
+// RBD image opened by librbd client
+type rbdImage struct {
+	pool      string
+	namespace string
+	name      string
+}
+
+// Pool names known from `ceph df detail` of health collector, map[string]bool
+var knownPools atomic.Value
+
+// Parse images opened by client from `help` output, which maps admin socket commands to descriptions
+func ParseRBDImages(data []byte) []rbdImage {
+	var commands map[string]interface{}
+	if err := json.Unmarshal(data, &commands); err != nil {
+		log.Debug("Failed to parse help: ", err)
+		return nil
+	}
+	var images []rbdImage
+	for command := range commands {
+		if !strings.HasPrefix(command, rbdCacheFlushCommand) {
+			continue
+		}
+		// Pool, namespace and image names never contain "/"
+		parts := strings.Split(strings.TrimPrefix(command, rbdCacheFlushCommand), "/")
+		switch len(parts) {
+		case 2:
+			images = append(images, rbdImage{pool: parts[0], name: parts[1]})
+		case 3:
+			images = append(images, rbdImage{pool: parts[0], namespace: parts[1], name: parts[2]})
+		}
+	}
+	return images
+}
+
+// Query images opened by librbd client. Command list is cached together with schema,
+// so it's refreshed when new image appears in perf dump (see DropSchema).
+func CollectRBDImages(ctx context.Context, socket string, version string, result *socketResult) []rbdImage {
+	commandStart := time.Now()
+	help, _, err := GetSchema(ctx, socket, "help", version)
+	result.commands["help"] = time.Since(commandStart).Seconds()
+	if err != nil {
+		// Images are still exported, pool is guessed
+		log.Warn("Failed to query images of socket ", socket, ": ", err)
+		result.failures = append(result.failures, newSocketError("help", err))
+		return nil
+	}
+	return ParseRBDImages([]byte(help))
+}
+
+// Whether client has librbd perf sections
+func HasRBDImages(counters []perfCounter) bool {
+	for _, counter := range counters {
+		if strings.HasPrefix(counter.section, "librbd-") {
+			return true
+		}
+	}
+	return false
+}
+
+// Remember pool names exported by health collector
+func UpdateKnownPools(health []cephHealthData) {
+	pools := make(map[string]bool)
+	for _, metric := range health {
+		if metric.name == "ceph_pool_stored_bytes" {
+			pools[metric.labels["pool"]] = true
+		}
+	}
+	knownPools.Store(pools)
+}
+
+// Namespace of "<pool>[/<namespace>]-<image>" part of perf section when it belongs to this image
+func (image rbdImage) Match(name string) (string, bool) {
+	suffix := "-" + image.name
+	if len(name) < len(image.pool)+len(suffix) || !strings.HasPrefix(name, image.pool) || !strings.HasSuffix(name, suffix) {
+		return "", false
+	}
+	namespace := name[len(image.pool) : len(name)-len(suffix)]
+	if namespace == "" {
+		return "", true
+	}
+	if !strings.HasPrefix(namespace, "/") || (image.namespace != "" && namespace[1:] != image.namespace) {
+		return "", false
+	}
+	return namespace[1:], true
+}
+
+// Pool and image name are separated by dash in perf section, but both may contain dashes.
+// Images opened by client give exact split. Otherwise (e.g. help failed) longest pool name
+// known from health collector is used and split at first dash is the last resort.
+// Namespaced pool is followed by "/", so it's always exact.
+func ResolvePoolLabel(labels map[string]string, images []rbdImage) {
+	pool, hasPool := labels["pool"]
+	image, hasImage := labels["image"]
+	if !hasPool || !hasImage {
+		return
+	}
+	name := pool + "-" + image
+	if labels["namespace"] != "" {
+		name = pool + "/" + labels["namespace"] + "-" + image
+	}
+	var resolved *rbdImage
+	for i, known := range images {
+		if _, ok := known.Match(name); ok && (resolved == nil || len(known.pool) > len(resolved.pool)) {
+			resolved = &images[i]
+		}
+	}
+	if resolved != nil {
+		labels["pool"] = resolved.pool
+		labels["namespace"], _ = resolved.Match(name)
+		labels["image"] = resolved.name
+		return
+	}
+	pools, _ := knownPools.Load().(map[string]bool)
+	if labels["namespace"] != "" || len(pools) == 0 {
+		return
+	}
+	resolvedPool := ""
+	for known := range pools {
+		if len(known) > len(resolvedPool) && strings.HasPrefix(name, known+"-") {
+			resolvedPool = known
+		}
+	}
+	if resolvedPool != "" {
+		labels["pool"] = resolvedPool
+		labels["image"] = strings.TrimPrefix(name, resolvedPool+"-")
+	}
+}
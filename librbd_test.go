@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestParseRBDImages(t *testing.T) {
+	images := ParseRBDImages([]byte(`{
+		"help": "list available commands",
+		"rbd cache flush cinder-volumes/volume-1": "flush rbd image cinder-volumes/volume-1 cache",
+		"rbd cache invalidate cinder-volumes/volume-1": "invalidate rbd image cinder-volumes/volume-1 cache",
+		"rbd cache flush rbd/tenant1/vm-100-disk-0": "flush rbd image rbd/tenant1/vm-100-disk-0 cache"
+	}`))
+	expected := map[rbdImage]bool{
+		{pool: "cinder-volumes", name: "volume-1"}:                 true,
+		{pool: "rbd", namespace: "tenant1", name: "vm-100-disk-0"}: true,
+	}
+	if len(images) != len(expected) {
+		t.Fatalf("Wrong number of images. Got: %v", images)
+	}
+	for _, image := range images {
+		if !expected[image] {
+			t.Errorf("Unexpected image: %v", image)
+		}
+	}
+	if images := ParseRBDImages([]byte("not json")); images != nil {
+		t.Errorf("Failed help should give no images. Got: %v", images)
+	}
+}
+
+func TestApplySectionRulesLibrbd(t *testing.T) {
+	defer knownPools.Store(map[string]bool{})
+	sections := []perfCounter{
+		{section: "librbd-abc123-cinder-volumes-volume-1", name: "rd"},
+		{section: "librbd-abc124-cinder-volumes-volume-1", name: "rd"},
+		{section: "librbd-abc125-rbd-ssd-vm-100-disk-0", name: "rd"},
+		{section: "librbd-abc126-rbd-ssd/tenant1-vm-1", name: "rd"},
+	}
+	expected := []map[string]string{
+		{"image_id": "abc123", "pool": "cinder-volumes", "namespace": "", "image": "volume-1"},
+		{"image_id": "abc124", "pool": "cinder-volumes", "namespace": "", "image": "volume-1"},
+		{"image_id": "abc125", "pool": "rbd-ssd", "namespace": "", "image": "vm-100-disk-0"},
+		{"image_id": "abc126", "pool": "rbd-ssd", "namespace": "tenant1", "image": "vm-1"},
+	}
+
+	// Images opened by client give exact pool and image names
+	knownPools.Store(map[string]bool{})
+	images := []rbdImage{
+		{pool: "cinder", name: "volumes-other"},
+		{pool: "cinder-volumes", name: "volume-1"},
+		{pool: "rbd-ssd", name: "vm-100-disk-0"},
+		{pool: "rbd-ssd", name: "vm-1"},
+	}
+	counters := ApplySectionRules(append([]perfCounter{}, sections...), images)
+	if len(counters) != len(expected) {
+		t.Fatalf("Images with the same name should not be dropped. Got: %v", counters)
+	}
+	for i, labels := range expected {
+		if !reflect.DeepEqual(counters[i].labels, labels) {
+			t.Errorf("Wrong librbd labels from client images. Got: %v, needed: %v", counters[i].labels, labels)
+		}
+	}
+
+	// Without images and known pools pool is split at first dash
+	counters = ApplySectionRules(append([]perfCounter{}, sections...), nil)
+	if counters[0].labels["pool"] != "cinder" || counters[0].labels["image"] != "volumes-volume-1" {
+		t.Errorf("Unknown pool should be split at first dash. Got: %v", counters[0].labels)
+	}
+
+	// Pool names from health collector are used when client images are unknown
+	UpdateKnownPools([]cephHealthData{
+		{name: "ceph_pool_stored_bytes", labels: PoolLabels("cinder-volumes", 3)},
+		{name: "ceph_pool_stored_bytes", labels: PoolLabels("cinder", 4)},
+		{name: "ceph_pool_stored_bytes", labels: PoolLabels("rbd-ssd", 5)},
+	})
+	counters = ApplySectionRules(append([]perfCounter{}, sections...), nil)
+	for i, labels := range expected {
+		if !reflect.DeepEqual(counters[i].labels, labels) {
+			t.Errorf("Wrong librbd labels from known pools. Got: %v, needed: %v", counters[i].labels, labels)
+		}
+	}
+}
+
+func TestCollectSocketLibrbd(t *testing.T) {
+	socket, stop := fakeAsokServer(t, map[string]string{
+		"version":     `{"version":"17.2.6","release":"quincy","release_type":"stable"}`,
+		"help":        `{"rbd cache flush cinder-volumes/volume-1": "flush rbd image cinder-volumes/volume-1 cache"}`,
+		"perf schema": `{"librbd-abc123-cinder-volumes-volume-1":{"rd":{"type":10,"description":"Reads"}}}`,
+		"perf dump":   `{"librbd-abc123-cinder-volumes-volume-1":{"rd":7}}`,
+	})
+	defer stop()
+
+	result := CollectSocket(context.Background(), socket)
+	if result.err != nil || len(result.counters) != 1 {
+		t.Fatalf("CollectSocket failed: %v, %v", result.counters, result.err)
+	}
+	labels := result.counters[0].labels
+	if labels["pool"] != "cinder-volumes" || labels["image"] != "volume-1" {
+		t.Errorf("Pool and image should be taken from client commands. Got: %v", labels)
+	}
+	if _, ok := result.commands["help"]; !ok {
+		t.Errorf("help command duration is missing: %v", result.commands)
+	}
+}
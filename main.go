@@ -10,11 +10,19 @@ import (
 
 var (
 	bindAddr        = flag.String("telemetry.addr", ":9353", "host:port for ceph exporter")
-	asokPath        = flag.String("asok.path", "/var/run/ceph", "path to ceph admin socket direcotry")
+	asokPath        = flag.String("asok.path", "/var/run/ceph", "Comma separated list of ceph admin socket directories or glob patterns")
 	queryInterval   = flag.Int("query.interval", 15, "How often should daemon read asok metrics (in seconds0")
 	logLevel        = flag.String("log.level", "info", "Logging level")
 	healthCollector = flag.Bool("health.collector", false, "Collect health status from ceph monitor")
 	cephConfigFile  = flag.String("config.file", "/etc/ceph/ceph.conf", "Path to ceph config file")
+	queryWorkers    = flag.Int("query.workers", 8, "How many sockets should be queried in parallel")
+	queryTimeout    = flag.Int("query.timeout", 10, "Deadline for whole collection cycle (in seconds)")
+	commandTimeout  = flag.Int("command.timeout", 5, "Deadline for a single ceph command (in seconds)")
+	perfAvgtime     = flag.Bool("perf.avgtime", false, "Export average time of long running averages as separate gauge")
+	perfHistograms  = flag.Bool("perf.histograms", false, "Export perf histograms (e.g. OSD latency by request size) as histograms")
+	perfRules       = flag.String("perf.rules", "", "Path to JSON file with perf section rules, replaces built-in rules")
+	asokWatch       = flag.Bool("asok.watch", false, "Watch admin socket directory and collect new sockets immediately")
+	asokGrace       = flag.Int("asok.grace", 0, "How long metrics of disappeared socket are still exported (in seconds)")
 )
 
 func main() {
@@ -30,6 +38,17 @@ func main() {
 		log.SetLevel(log.InfoLevel)
 	}
 
+	if *perfRules != "" {
+		if err := LoadSectionRules(*perfRules); err != nil {
+			log.Fatal("Failed to load perf section rules: ", err)
+		}
+	}
+
+	if *asokWatch {
+		if err := WatchSockets(SocketDirs()); err != nil {
+			log.Error("Failed to watch admin sockets, relying on periodic rescan: ", err)
+		}
+	}
 	go CollectTimer(*queryInterval)
 
 	ceph := newCephCollector()
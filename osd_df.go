@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	log "github.com/sirupsen/logrus"
+)
+
+// Part of `ceph osd df` output. Sizes are in KiB.
+type cephOSDDF struct {
+	Nodes   []cephOSDDFNode `json:"nodes"`
+	Stray   []cephOSDDFNode `json:"stray"`
+	Summary struct {
+		AverageUtilization float64 `json:"average_utilization"`
+		MinVar             float64 `json:"min_var"`
+		MaxVar             float64 `json:"max_var"`
+		Dev                float64 `json:"dev"`
+	} `json:"summary"`
+}
+
+type cephOSDDFNode struct {
+	ID          int     `json:"id"`
+	KB          float64 `json:"kb"`
+	KBUsed      float64 `json:"kb_used"`
+	KBAvail     float64 `json:"kb_avail"`
+	Utilization float64 `json:"utilization"`
+	Var         float64 `json:"var"`
+	PGs         float64 `json:"pgs"`
+}
+
+// Build per OSD utilization metrics from `ceph osd df` output.
+// OSD labels are the same as of OSD state metrics.
+func ParseOSDDF(data []byte, treeData []byte) []cephHealthData {
+	var df cephOSDDF
+	if err := json.Unmarshal(data, &df); err != nil {
+		log.Debug("Failed to parse osd df: ", err)
+		return nil
+	}
+	locations := ParseOSDTree(treeData)
+	var metrics []cephHealthData
+	for _, node := range append(df.Nodes, df.Stray...) {
+		labels := OSDLabels(node.ID, locations)
+		metrics = append(metrics,
+			cephHealthData{name: "ceph_osd_size_bytes", value: node.KB * 1024, metricType: GaugeValue, help: "OSD size", labels: labels},
+			cephHealthData{name: "ceph_osd_used_bytes", value: node.KBUsed * 1024, metricType: GaugeValue, help: "Used space of OSD", labels: labels},
+			cephHealthData{name: "ceph_osd_avail_bytes", value: node.KBAvail * 1024, metricType: GaugeValue, help: "Available space of OSD", labels: labels},
+			cephHealthData{name: "ceph_osd_utilization", value: node.Utilization, metricType: GaugeValue, help: "OSD utilization in percent", labels: labels},
+			cephHealthData{name: "ceph_osd_variance", value: node.Var, metricType: GaugeValue, help: "OSD utilization relative to average", labels: labels},
+			cephHealthData{name: "ceph_osd_pgs", value: node.PGs, metricType: GaugeValue, help: "Number of PGs on OSD", labels: labels},
+		)
+	}
+	summary := df.Summary
+	metrics = append(metrics,
+		cephHealthData{name: "ceph_osd_utilization_average", value: summary.AverageUtilization, metricType: GaugeValue, help: "Average OSD utilization in percent"},
+		cephHealthData{name: "ceph_osd_variance_min", value: summary.MinVar, metricType: GaugeValue, help: "Minimal OSD utilization variance"},
+		cephHealthData{name: "ceph_osd_variance_max", value: summary.MaxVar, metricType: GaugeValue, help: "Maximal OSD utilization variance"},
+		cephHealthData{name: "ceph_osd_utilization_stddev", value: summary.Dev, metricType: GaugeValue, help: "Standard deviation of OSD utilization"},
+	)
+	return metrics
+}
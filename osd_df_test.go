@@ -0,0 +1,54 @@
+package main
+
+import "testing"
+
+func TestParseOSDDF(t *testing.T) {
+	df := []byte(`{
+		"nodes": [
+			{"id": 0, "device_class": "ssd", "name": "osd.0", "type": "osd", "type_id": 0, "crush_weight": 1.7469, "depth": 3, "pool_weights": {}, "reweight": 1, "kb": 1048576, "kb_used": 262144, "kb_used_data": 200000, "kb_used_omap": 1000, "kb_used_meta": 61144, "kb_avail": 786432, "utilization": 25, "var": 1.25, "pgs": 33, "status": "up"}
+		],
+		"stray": [
+			{"id": 2, "device_class": "", "name": "osd.2", "type": "osd", "type_id": 0, "crush_weight": 0, "depth": 0, "pool_weights": {}, "reweight": 0, "kb": 0, "kb_used": 0, "kb_avail": 0, "utilization": 0, "var": 0, "pgs": 0, "status": "down"}
+		],
+		"summary": {"total_kb": 1048576, "total_kb_used": 262144, "total_kb_avail": 786432, "average_utilization": 20, "min_var": 0.75, "max_var": 1.25, "dev": 3.5}
+	}`)
+	tree := []byte(`{"nodes": [
+		{"id": -1, "name": "default", "type": "root", "children": [-3]},
+		{"id": -3, "name": "host1", "type": "host", "children": [0]},
+		{"id": 0, "device_class": "ssd", "name": "osd.0", "type": "osd", "crush_weight": 1.7469}
+	]}`)
+	metrics := ParseOSDDF(df, tree)
+	osd0 := map[string]string{"ceph_daemon": "osd.0", "device_class": "ssd", "host": "host1", "rack": "", "root": "default"}
+	expected := map[string]float64{
+		"ceph_osd_size_bytes":  1073741824,
+		"ceph_osd_used_bytes":  268435456,
+		"ceph_osd_avail_bytes": 805306368,
+		"ceph_osd_utilization": 25,
+		"ceph_osd_variance":    1.25,
+		"ceph_osd_pgs":         33,
+	}
+	for name, value := range expected {
+		metric, ok := findHealthMetric(metrics, name, osd0)
+		if !ok || metric.value != value {
+			t.Errorf("Wrong %s of osd.0. Got: %v, needed: %v", name, metric.value, value)
+		}
+	}
+	stray := map[string]string{"ceph_daemon": "osd.2", "device_class": "", "host": "", "rack": "", "root": ""}
+	if _, ok := findHealthMetric(metrics, "ceph_osd_size_bytes", stray); !ok {
+		t.Errorf("Stray OSD is missing")
+	}
+	summary := map[string]float64{
+		"ceph_osd_utilization_average": 20,
+		"ceph_osd_variance_min":        0.75,
+		"ceph_osd_variance_max":        1.25,
+		"ceph_osd_utilization_stddev":  3.5,
+	}
+	for name, value := range summary {
+		if metric, ok := findHealthMetric(metrics, name, nil); !ok || metric.value != value {
+			t.Errorf("Wrong %s. Got: %v, needed: %v", name, metric.value, value)
+		}
+	}
+	if metrics := ParseOSDDF(nil, tree); metrics != nil {
+		t.Errorf("Failed osd df should export nothing. Got: %v", metrics)
+	}
+}
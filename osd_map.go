@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	log "github.com/sirupsen/logrus"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Part of `ceph osd dump` output
+type cephOSDDump struct {
+	Fsid string `json:"fsid"`
+	// Comma separated flags, e.g. "noout,sortbitwise,recovery_deletes"
+	Flags string `json:"flags"`
+	OSDs  []struct {
+		OSD             int     `json:"osd"`
+		Up              float64 `json:"up"`
+		In              float64 `json:"in"`
+		Weight          float64 `json:"weight"`
+		PrimaryAffinity float64 `json:"primary_affinity"`
+	} `json:"osds"`
+}
+
+// Part of `ceph osd tree` output. Nodes are CRUSH buckets (host, rack, root, ...) and OSDs,
+// stray OSDs exist in OSD map, but not in CRUSH hierarchy.
+type cephOSDTree struct {
+	Nodes []cephOSDTreeNode `json:"nodes"`
+	Stray []cephOSDTreeNode `json:"stray"`
+}
+
+type cephOSDTreeNode struct {
+	ID          int     `json:"id"`
+	Name        string  `json:"name"`
+	Type        string  `json:"type"`
+	CrushWeight float64 `json:"crush_weight"`
+	DeviceClass string  `json:"device_class"`
+	Children    []int   `json:"children"`
+}
+
+// CRUSH bucket types exported as location labels of every OSD
+var osdLocationLabels = []string{"host", "rack", "root"}
+
+// Cluster flags which are always exported, even when unset.
+// Other flags (e.g. full and nearfull set before Mimic) are exported only while set.
+var clusterFlags = []string{
+	"noout", "noin", "nodown", "noup", "norebalance", "norecover", "nobackfill",
+	"noscrub", "nodeep-scrub", "notieragent", "pauserd", "pausewr", "pause",
+}
+
+// Build cluster flag metrics from `ceph osd dump` output
+func ParseOSDDump(data []byte) []cephHealthData {
+	var dump cephOSDDump
+	if err := json.Unmarshal(data, &dump); err != nil {
+		log.Debug("Failed to parse osd dump: ", err)
+		return nil
+	}
+	flags := make(map[string]float64, len(clusterFlags))
+	for _, flag := range clusterFlags {
+		flags[flag] = 0
+	}
+	for _, flag := range strings.Split(dump.Flags, ",") {
+		if flag != "" {
+			flags[flag] = 1
+		}
+	}
+	// `ceph osd set pause` is stored as pauserd and pausewr, pause means any client IO is paused
+	flags["pause"] = math.Max(flags["pauserd"], flags["pausewr"])
+	var metrics []cephHealthData
+	for flag, value := range flags {
+		metrics = append(metrics, cephHealthData{name: "ceph_cluster_flag", value: value, metricType: GaugeValue, help: "Status of OSD map flag (0:unset, 1:set)", labels: map[string]string{"flag": flag}})
+	}
+	metrics = append(metrics, cephHealthData{name: "ceph_cluster_noout_flag", value: flags["noout"], metricType: GaugeValue, help: "Status of noout flag (0:unset, 1:set)"})
+	for i := range metrics {
+		metrics[i].fsid = dump.Fsid
+	}
+	return metrics
+}
+
+// CRUSH location of OSDs together with CRUSH weight.
+// Stray OSDs have no location, OSDs missing in tree have no weight either.
+func OSDLocations(tree cephOSDTree) (map[int]map[string]string, map[int]float64) {
+	parents := make(map[int]int)
+	for _, node := range tree.Nodes {
+		for _, child := range node.Children {
+			parents[child] = node.ID
+		}
+	}
+	nodes := make(map[int]int, len(tree.Nodes))
+	for i, node := range tree.Nodes {
+		nodes[node.ID] = i
+	}
+	locations := make(map[int]map[string]string)
+	weights := make(map[int]float64)
+	for _, node := range tree.Nodes {
+		if node.Type != "osd" {
+			continue
+		}
+		location := make(map[string]string)
+		// Walk up to CRUSH root
+		for id, ok := parents[node.ID]; ok; id, ok = parents[id] {
+			bucket := tree.Nodes[nodes[id]]
+			location[bucket.Type] = bucket.Name
+		}
+		location["device_class"] = node.DeviceClass
+		locations[node.ID] = location
+		weights[node.ID] = node.CrushWeight
+	}
+	for _, node := range tree.Stray {
+		if node.Type != "osd" {
+			continue
+		}
+		locations[node.ID] = map[string]string{"device_class": node.DeviceClass}
+		weights[node.ID] = node.CrushWeight
+	}
+	return locations, weights
+}
+
+// Labels of OSD metrics: OSD name, device class and CRUSH location.
+// OSDs missing in osd tree get empty location.
+func OSDLabels(osd int, locations map[int]map[string]string) map[string]string {
+	location := locations[osd]
+	labels := map[string]string{"ceph_daemon": "osd." + strconv.Itoa(osd), "device_class": location["device_class"]}
+	for _, label := range osdLocationLabels {
+		labels[label] = location[label]
+	}
+	return labels
+}
+
+// Parse `ceph osd tree` output into OSD locations
+func ParseOSDTree(data []byte) map[int]map[string]string {
+	var tree cephOSDTree
+	if err := json.Unmarshal(data, &tree); err != nil {
+		log.Debug("Failed to parse osd tree: ", err)
+	}
+	locations, _ := OSDLocations(tree)
+	return locations
+}
+
+// Build per OSD state metrics from `ceph osd dump` and `ceph osd tree` output.
+// OSDs are reported by cluster, so OSD on a dead host is still exported.
+func ParseOSDStates(dumpData []byte, treeData []byte) []cephHealthData {
+	var dump cephOSDDump
+	if err := json.Unmarshal(dumpData, &dump); err != nil {
+		log.Debug("Failed to parse osd dump: ", err)
+		return nil
+	}
+	var tree cephOSDTree
+	if err := json.Unmarshal(treeData, &tree); err != nil {
+		// OSD states are still useful without CRUSH location
+		log.Debug("Failed to parse osd tree: ", err)
+	}
+	locations, weights := OSDLocations(tree)
+	var metrics []cephHealthData
+	for _, osd := range dump.OSDs {
+		labels := OSDLabels(osd.OSD, locations)
+		metrics = append(metrics,
+			cephHealthData{name: "ceph_osd_up", value: osd.Up, metricType: GaugeValue, help: "Is OSD up (1:up, 0:down)", labels: labels},
+			cephHealthData{name: "ceph_osd_in", value: osd.In, metricType: GaugeValue, help: "Is OSD in (1:in, 0:out)", labels: labels},
+			cephHealthData{name: "ceph_osd_reweight", value: osd.Weight, metricType: GaugeValue, help: "Reweight (override weight) of OSD", labels: labels},
+			cephHealthData{name: "ceph_osd_primary_affinity", value: osd.PrimaryAffinity, metricType: GaugeValue, help: "Primary affinity of OSD", labels: labels},
+		)
+		// CRUSH weight is unknown when osd tree failed
+		if weight, ok := weights[osd.OSD]; ok {
+			metrics = append(metrics, cephHealthData{name: "ceph_osd_weight", value: weight, metricType: GaugeValue, help: "CRUSH weight of OSD", labels: labels})
+		}
+	}
+	for i := range metrics {
+		metrics[i].fsid = dump.Fsid
+	}
+	return metrics
+}
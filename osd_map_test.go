@@ -0,0 +1,94 @@
+package main
+
+import "testing"
+
+func TestParseOSDDump(t *testing.T) {
+	metrics := ParseOSDDump([]byte(`{
+		"epoch": 42,
+		"fsid": "0b3a1b0c-5f7e-11ee-8c99-0242ac120002",
+		"flags": "noout,nodeep-scrub,sortbitwise,recovery_deletes,purged_snapdirs,pglog_hardlimit",
+		"flags_num": 1736704
+	}`))
+	flags := map[string]float64{"noout": 1, "nodeep-scrub": 1, "sortbitwise": 1, "noin": 0, "pauserd": 0, "pausewr": 0, "pause": 0}
+	for flag, expected := range flags {
+		metric, ok := findHealthMetric(metrics, "ceph_cluster_flag", map[string]string{"flag": flag})
+		if !ok || metric.value != expected {
+			t.Errorf("Wrong %s flag. Got: %v, needed: %v", flag, metric.value, expected)
+		}
+		if metric.fsid != "0b3a1b0c-5f7e-11ee-8c99-0242ac120002" {
+			t.Errorf("Flag metric has wrong fsid: %s", metric.fsid)
+		}
+	}
+	if _, ok := findHealthMetric(metrics, "ceph_cluster_flag", map[string]string{"flag": "full"}); ok {
+		t.Errorf("Unset full flag should not be exported")
+	}
+	if noout, _ := findHealthMetric(metrics, "ceph_cluster_noout_flag", nil); noout.value != 1 {
+		t.Errorf("noout flag should be set")
+	}
+
+	// `ceph osd set pause` sets pauserd and pausewr
+	metrics = ParseOSDDump([]byte(`{"fsid": "0b3a1b0c-5f7e-11ee-8c99-0242ac120002", "flags": "pauserd,pausewr,sortbitwise"}`))
+	for _, flag := range []string{"pauserd", "pausewr", "pause"} {
+		if metric, _ := findHealthMetric(metrics, "ceph_cluster_flag", map[string]string{"flag": flag}); metric.value != 1 {
+			t.Errorf("%s flag should be set. Got: %v", flag, metric.value)
+		}
+	}
+	if metrics := ParseOSDDump(nil); metrics != nil {
+		t.Errorf("Failed osd dump should export nothing. Got: %v", metrics)
+	}
+}
+
+func TestParseOSDStates(t *testing.T) {
+	dump := []byte(`{
+		"fsid": "0b3a1b0c-5f7e-11ee-8c99-0242ac120002",
+		"osds": [
+			{"osd": 0, "up": 1, "in": 1, "weight": 1, "primary_affinity": 1},
+			{"osd": 1, "up": 0, "in": 1, "weight": 0.8, "primary_affinity": 0.5},
+			{"osd": 2, "up": 0, "in": 0, "weight": 0, "primary_affinity": 1}
+		]
+	}`)
+	tree := []byte(`{
+		"nodes": [
+			{"id": -1, "name": "default", "type": "root", "type_id": 11, "children": [-5]},
+			{"id": -5, "name": "rack1", "type": "rack", "type_id": 3, "children": [-3, -7]},
+			{"id": -3, "name": "host1", "type": "host", "type_id": 1, "children": [0]},
+			{"id": -7, "name": "host2", "type": "host", "type_id": 1, "children": [1]},
+			{"id": 0, "device_class": "ssd", "name": "osd.0", "type": "osd", "type_id": 0, "crush_weight": 1.7469, "depth": 3, "status": "up", "reweight": 1, "primary_affinity": 1},
+			{"id": 1, "device_class": "hdd", "name": "osd.1", "type": "osd", "type_id": 0, "crush_weight": 3.6387, "depth": 3, "status": "down", "reweight": 0.8, "primary_affinity": 0.5}
+		],
+		"stray": [{"id": 2, "name": "osd.2", "type": "osd", "type_id": 0, "crush_weight": 0, "depth": 0, "status": "down", "reweight": 0, "primary_affinity": 1}]
+	}`)
+	metrics := ParseOSDStates(dump, tree)
+	osd1 := map[string]string{"ceph_daemon": "osd.1", "device_class": "hdd", "host": "host2", "rack": "rack1", "root": "default"}
+	expected := map[string]float64{
+		"ceph_osd_up":               0,
+		"ceph_osd_in":               1,
+		"ceph_osd_weight":           3.6387,
+		"ceph_osd_reweight":         0.8,
+		"ceph_osd_primary_affinity": 0.5,
+	}
+	for name, value := range expected {
+		metric, ok := findHealthMetric(metrics, name, osd1)
+		if !ok || metric.value != value {
+			t.Errorf("Wrong %s of osd.1. Got: %v, needed: %v", name, metric.value, value)
+		}
+	}
+	// OSD outside of CRUSH hierarchy is exported with empty location
+	stray := map[string]string{"ceph_daemon": "osd.2", "device_class": "", "host": "", "rack": "", "root": ""}
+	if _, ok := findHealthMetric(metrics, "ceph_osd_up", stray); !ok {
+		t.Errorf("OSD without CRUSH location is missing: %v", metrics)
+	}
+	if weight, ok := findHealthMetric(metrics, "ceph_osd_weight", stray); !ok || weight.value != 0 {
+		t.Errorf("Stray OSD should have zero CRUSH weight. Got: %v", weight)
+	}
+	// Without osd tree OSD states are still exported, but CRUSH weight is unknown
+	metrics = ParseOSDStates(dump, nil)
+	if len(metrics) != 12 {
+		t.Errorf("OSD states should be exported without osd tree. Got: %d metrics", len(metrics))
+	}
+	for _, metric := range metrics {
+		if metric.name == "ceph_osd_weight" {
+			t.Errorf("CRUSH weight should not be exported without osd tree. Got: %v", metric)
+		}
+	}
+}
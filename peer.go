@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	log "github.com/sirupsen/logrus"
+	"strings"
+)
+
+// Process owning admin socket, e.g. qemu process of a VM using librbd
+type socketPeer struct {
+	pid     int
+	process string
+	// Guest name of qemu process, empty for other processes
+	vm string
+}
+
+// Guest name from qemu command line: -name guest=vm1,debug-threads=on or -name vm1
+func QemuGuestName(args []string) string {
+	for i, arg := range args {
+		if (arg != "-name" && arg != "--name") || i+1 >= len(args) {
+			continue
+		}
+		for n, option := range strings.Split(args[i+1], ",") {
+			if strings.HasPrefix(option, "guest=") {
+				return strings.TrimPrefix(option, "guest=")
+			}
+			if n == 0 && !strings.Contains(option, "=") {
+				return option
+			}
+		}
+	}
+	return ""
+}
+
+// Get process owning socket. Peer is resolved once and kept in schema cache
+// together with socket identity, so restarted process is resolved again.
+func GetPeer(ctx context.Context, socket string) *socketPeer {
+	schemaMutex.Lock()
+	cached := schema[socket]
+	var peer *socketPeer
+	if cached != nil {
+		peer = cached.peer
+	}
+	schemaMutex.Unlock()
+	if cached == nil || peer != nil {
+		return peer
+	}
+	peer, err := SocketPeer(ctx, socket)
+	if err != nil {
+		log.Debug("Failed to resolve process of socket ", socket, ": ", err)
+		return nil
+	}
+	schemaMutex.Lock()
+	cached.peer = peer
+	schemaMutex.Unlock()
+	return peer
+}
@@ -0,0 +1,58 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"golang.org/x/sys/unix"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Resolve process owning admin socket from socket peer credentials (SO_PEERCRED).
+// For connected unix socket these are credentials of the process which listens on it.
+func SocketPeer(ctx context.Context, socket string) (*socketPeer, error) {
+	ctx, cancel := CommandContext(ctx)
+	defer cancel()
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("dial %s: %w", socket, err)
+	}
+	defer conn.Close()
+	raw, err := conn.(*net.UnixConn).SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var ucred *unix.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		ucred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	})
+	if err == nil {
+		err = credErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("peer credentials of %s: %w", socket, err)
+	}
+	return ProcessPeer(int(ucred.Pid))
+}
+
+// Read process name and VM name (for qemu) of a process from /proc
+func ProcessPeer(pid int) (*socketPeer, error) {
+	proc := "/proc/" + strconv.Itoa(pid)
+	comm, err := ioutil.ReadFile(proc + "/comm")
+	if err != nil {
+		return nil, err
+	}
+	peer := &socketPeer{pid: pid, process: strings.TrimSpace(string(comm))}
+	cmdline, err := ioutil.ReadFile(proc + "/cmdline")
+	if err == nil {
+		peer.vm = QemuGuestName(strings.Split(strings.TrimRight(string(cmdline), "\x00"), "\x00"))
+	}
+	return peer, nil
+}
@@ -0,0 +1,26 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+)
+
+func TestSocketPeer(t *testing.T) {
+	socket, stop := fakeAsokServer(t, map[string]string{})
+	defer stop()
+
+	peer, err := SocketPeer(context.Background(), socket)
+	if err != nil {
+		t.Fatalf("SocketPeer failed: %v", err)
+	}
+	if peer.pid != os.Getpid() || peer.process == "" {
+		t.Errorf("SocketPeer should resolve own process. Got: %+v", peer)
+	}
+	if _, err := SocketPeer(context.Background(), socket+".missing"); err == nil {
+		t.Errorf("SocketPeer should fail on missing socket")
+	}
+}
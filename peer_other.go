@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// Socket peer credentials are implemented only with linux SO_PEERCRED
+func SocketPeer(ctx context.Context, socket string) (*socketPeer, error) {
+	return nil, errors.New("admin socket peer credentials are supported only on linux")
+}
@@ -0,0 +1,17 @@
+package main
+
+import "testing"
+
+func TestQemuGuestName(t *testing.T) {
+	cases := map[string][]string{
+		"vm1":        {"/usr/bin/qemu-system-x86_64", "-name", "guest=vm1,debug-threads=on", "-S"},
+		"instance-2": {"qemu-kvm", "-name", "instance-2", "-m", "1024"},
+		"vm3":        {"qemu-kvm", "-name", "vm3,process=qemu:vm3"},
+		"":           {"/usr/bin/rbd", "map", "rbd/image"},
+	}
+	for expected, args := range cases {
+		if name := QemuGuestName(args); name != expected {
+			t.Errorf("QemuGuestName(%v) failed. Got: %q, needed: %q", args, name, expected)
+		}
+	}
+}
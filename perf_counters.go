@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Perf counter type is a bitmask.
+// https://docs.ceph.com/en/latest/dev/perf_counters/
+const (
+	perfCounterTime       = 1
+	perfCounterU64        = 2
+	perfCounterLongRunAvg = 4
+	perfCounterCounter    = 8
+	perfCounterHistogram  = 16
+)
+
+type perfCounterType int
+
+func (t perfCounterType) IsTime() bool {
+	return t&perfCounterTime != 0
+}
+
+func (t perfCounterType) IsLongRunAvg() bool {
+	return t&perfCounterLongRunAvg != 0
+}
+
+func (t perfCounterType) IsCounter() bool {
+	return t&perfCounterCounter != 0
+}
+
+func (t perfCounterType) IsHistogram() bool {
+	return t&perfCounterHistogram != 0
+}
+
+// Prometheus type of a plain (not long running average) value
+func (t perfCounterType) ValueType() prometheus.ValueType {
+	if t.IsCounter() {
+		return prometheus.CounterValue
+	}
+	return prometheus.GaugeValue
+}
+
+// Single perf counter of a daemon with its value(s) already decoded
+type perfCounter struct {
+	section     string
+	name        string
+	description string
+	counterType perfCounterType
+	// Plain counter value
+	value float64
+	// Long running average values. avgtime is reported only for time averages.
+	count      float64
+	sum        float64
+	avgtime    float64
+	hasAvgtime bool
+	// Histogram from `perf histogram dump`
+	histogram *perfHistogram
+	// Labels of labeled counters from `counter dump`
+	labels map[string]string
+}
+
+// Decode perf dump using perf schema.
+// Counters missing in schema (e.g. section created after schema was taken) are skipped
+// and reported together with decoded counters as errMissingSchema.
+func ParsePerfCounters(schema map[string]interface{}, metrics map[string]interface{}) ([]perfCounter, error) {
+	var counters []perfCounter
+	var missing []string
+	for section, sectionData := range metrics {
+		sectionSchema, ok := schema[section].(map[string]interface{})
+		if !ok {
+			missing = append(missing, section)
+			continue
+		}
+		sectionValues, ok := sectionData.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s is not a map", section)
+		}
+		for name, value := range sectionValues {
+			counterSchema, ok := sectionSchema[name].(map[string]interface{})
+			if !ok {
+				missing = append(missing, section+"."+name)
+				continue
+			}
+			counterType, ok := counterSchema["type"].(float64)
+			if !ok {
+				missing = append(missing, section+"."+name)
+				continue
+			}
+			description, _ := counterSchema["description"].(string)
+			counter := perfCounter{
+				section:     section,
+				name:        name,
+				description: description,
+				counterType: perfCounterType(counterType),
+			}
+			if counter.counterType.IsHistogram() {
+				log.Debug("Histogram is exported from perf histogram dump, skipping: ", section, ".", name)
+				continue
+			}
+			if err := counter.decode(value); err != nil {
+				return nil, fmt.Errorf("%s.%s: %w", section, name, err)
+			}
+			counters = append(counters, counter)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return counters, fmt.Errorf("%w: %s", errMissingSchema, strings.Join(missing, ", "))
+	}
+	return counters, nil
+}
+
+// Decode counter value according to its type.
+// Ceph dumps time values as seconds with nanosecond precision ("%d.%09d"),
+// so time values are exported in seconds without any further scaling.
+func (counter *perfCounter) decode(value interface{}) error {
+	if !counter.counterType.IsLongRunAvg() {
+		v, err := perfCounterValue(value)
+		counter.value = v
+		return err
+	}
+	avg, ok := value.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("long running average is not a map: %v", value)
+	}
+	var err error
+	if counter.count, err = perfCounterValue(avg["avgcount"]); err != nil {
+		return err
+	}
+	if counter.sum, err = perfCounterValue(avg["sum"]); err != nil {
+		return err
+	}
+	if avgtime, ok := avg["avgtime"]; ok {
+		counter.hasAvgtime = true
+		if counter.avgtime, err = perfCounterValue(avgtime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func perfCounterValue(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case string:
+		return strconv.ParseFloat(v, 64)
+	}
+	return 0, fmt.Errorf("unexpected counter value: %v", value)
+}
+
+// Export perf counters of a single daemon
+func CollectPerfCounters(ch chan<- prometheus.Metric, device map[string]string, counters []perfCounter) {
+	for _, counter := range counters {
+		metricName := device["type"] + "_" + CephNormalizeMetricName(counter.section) + "_" + counter.name
+		labelNames, labelValues := counter.LabelPairs()
+		labelValues = append(DaemonLabelValues(device), labelValues...)
+		if counter.histogram != nil {
+			collectHistogram(ch, metricName, labelNames, labelValues, counter)
+			continue
+		}
+		description := CephPrometheusDesc(metricName, counter.description, labelNames...)
+		if !counter.counterType.IsLongRunAvg() {
+			ch <- prometheus.MustNewConstMetric(description, counter.counterType.ValueType(), counter.value, labelValues...)
+			continue
+		}
+		// Long running average is exported as summary without quantiles,
+		// so latency is rate(<metric>_sum)/rate(<metric>_count).
+		ch <- prometheus.MustNewConstSummary(description, uint64(counter.count), counter.sum, nil, labelValues...)
+		if counter.hasAvgtime && *perfAvgtime {
+			description = CephPrometheusDesc(metricName+"_avgtime", counter.description, labelNames...)
+			ch <- prometheus.MustNewConstMetric(description, prometheus.GaugeValue, counter.avgtime, labelValues...)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"testing"
+)
+
+func TestPerfCounterType(t *testing.T) {
+	tests := []struct {
+		counterType perfCounterType
+		valueType   prometheus.ValueType
+		time        bool
+		longRunAvg  bool
+		histogram   bool
+	}{
+		{1, prometheus.GaugeValue, true, false, false},
+		{2, prometheus.GaugeValue, false, false, false},
+		{5, prometheus.GaugeValue, true, true, false},
+		{6, prometheus.GaugeValue, false, true, false},
+		{9, prometheus.CounterValue, true, false, false},
+		{10, prometheus.CounterValue, false, false, false},
+		{13, prometheus.CounterValue, true, true, false},
+		{14, prometheus.CounterValue, false, true, false},
+		{18, prometheus.GaugeValue, false, false, true},
+		{26, prometheus.CounterValue, false, false, true},
+	}
+	for _, test := range tests {
+		if test.counterType.ValueType() != test.valueType {
+			t.Errorf("Type %d has wrong value type", test.counterType)
+		}
+		if test.counterType.IsTime() != test.time {
+			t.Errorf("Type %d has wrong TIME bit", test.counterType)
+		}
+		if test.counterType.IsLongRunAvg() != test.longRunAvg {
+			t.Errorf("Type %d has wrong LONGRUNAVG bit", test.counterType)
+		}
+		if test.counterType.IsHistogram() != test.histogram {
+			t.Errorf("Type %d has wrong HISTOGRAM bit", test.counterType)
+		}
+	}
+}
+
+func TestParsePerfCounters(t *testing.T) {
+	schema, _ := LoadJson(`{"osd": {
+		"numpg": {"type": 2, "description": "Placement groups"},
+		"op": {"type": 10, "description": "Client operations"},
+		"op_before_queue_op_lat": {"type": 1, "description": "Latency of IO before calling queue"},
+		"op_latency": {"type": 5, "description": "Latency of client operations"},
+		"op_wip": {"type": 6, "description": "Replication operations"},
+		"op_r_latency_out_bytes_histogram": {"type": 18, "description": "Histogram"}
+	}}`)
+	metrics, _ := LoadJson(`{"osd": {
+		"numpg": 128,
+		"op": 1000,
+		"op_before_queue_op_lat": 0.000123456,
+		"op_latency": {"avgcount": 10, "sum": 0.5, "avgtime": 0.05},
+		"op_wip": {"avgcount": 4, "sum": 8},
+		"op_r_latency_out_bytes_histogram": {}
+	}}`)
+	counters, err := ParsePerfCounters(schema, metrics)
+	if err != nil {
+		t.Fatalf("ParsePerfCounters failed: %v", err)
+	}
+	parsed := make(map[string]perfCounter)
+	for _, counter := range counters {
+		parsed[counter.name] = counter
+	}
+	if len(parsed) != 5 {
+		t.Errorf("Histogram should be skipped. Got: %v", parsed)
+	}
+	if parsed["numpg"].value != 128 || parsed["op"].value != 1000 {
+		t.Errorf("Plain values decoded wrong: %v, %v", parsed["numpg"], parsed["op"])
+	}
+	if parsed["op_before_queue_op_lat"].value != 0.000123456 {
+		t.Errorf("Time value should be in seconds. Got: %v", parsed["op_before_queue_op_lat"].value)
+	}
+	latency := parsed["op_latency"]
+	if latency.count != 10 || latency.sum != 0.5 || !latency.hasAvgtime || latency.avgtime != 0.05 {
+		t.Errorf("Time average decoded wrong: %v", latency)
+	}
+	if parsed["op_wip"].count != 4 || parsed["op_wip"].sum != 8 || parsed["op_wip"].hasAvgtime {
+		t.Errorf("U64 average decoded wrong: %v", parsed["op_wip"])
+	}
+
+	delete(schema["osd"].(map[string]interface{}), "op")
+	counters, err = ParsePerfCounters(schema, metrics)
+	if !errors.Is(err, errMissingSchema) {
+		t.Errorf("Missing schema error expected. Got: %v", err)
+	}
+	if len(counters) != 4 {
+		t.Errorf("Counters with schema should still be decoded. Got: %v", counters)
+	}
+}
+
+func TestCollectPerfCountersSummary(t *testing.T) {
+	device := map[string]string{"type": "ceph_osd", "ceph_daemon": "osd.0", "daemon_type": "osd"}
+	counters := []perfCounter{{section: "osd", name: "op_latency", counterType: 5, count: 10, sum: 0.5, avgtime: 0.05, hasAvgtime: true}}
+
+	*perfAvgtime = false
+	ch := make(chan prometheus.Metric, 10)
+	CollectPerfCounters(ch, device, counters)
+	close(ch)
+	if len(ch) != 1 {
+		t.Fatalf("Only summary should be exported. Got: %d metrics", len(ch))
+	}
+	var metric dto.Metric
+	if err := (<-ch).Write(&metric); err != nil {
+		t.Fatal(err)
+	}
+	summary := metric.GetSummary()
+	if summary.GetSampleCount() != 10 || summary.GetSampleSum() != 0.5 {
+		t.Errorf("Summary has wrong values: %v", summary)
+	}
+
+	*perfAvgtime = true
+	ch = make(chan prometheus.Metric, 10)
+	CollectPerfCounters(ch, device, counters)
+	if len(ch) != 2 {
+		t.Errorf("Average time gauge should be exported when enabled. Got: %d metrics", len(ch))
+	}
+	*perfAvgtime = false
+}
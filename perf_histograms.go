@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Axis of ceph perf histogram.
+// Bucket 0 holds values below min, last bucket holds everything above range of previous ones.
+// https://docs.ceph.com/en/latest/dev/perf_histograms/
+type histogramAxis struct {
+	Name      string  `json:"name"`
+	Min       float64 `json:"min"`
+	QuantSize float64 `json:"quant_size"`
+	Buckets   int     `json:"buckets"`
+	ScaleType string  `json:"scale_type"`
+}
+
+// Histogram as reported by `perf histogram dump`.
+// Values are indexed by first axis bucket and then by second axis bucket.
+type perfHistogram struct {
+	Axes   []histogramAxis `json:"axes"`
+	Values [][]float64     `json:"values"`
+}
+
+// Time axis units and their count in a second
+var axisTimeUnits = map[string]float64{
+	"(nsec)": 1e9,
+	"(usec)": 1e6,
+	"(msec)": 1e3,
+}
+
+var labelNameRegexp = regexp.MustCompile(`[^a-z0-9]+`)
+
+// Upper bounds of all buckets except the last (overflow) one.
+// Time axes are converted to seconds.
+func (axis histogramAxis) UpperBounds() []float64 {
+	divisor := 1.0
+	for unit, perSecond := range axisTimeUnits {
+		if strings.Contains(axis.Name, unit) {
+			divisor = perSecond
+		}
+	}
+	var bounds []float64
+	for i := 0; i < axis.Buckets-1; i++ {
+		var bound float64
+		switch {
+		case i == 0:
+			bound = axis.Min
+		case axis.ScaleType == "log2":
+			bound = axis.Min + axis.QuantSize*math.Exp2(float64(i-1))
+		default:
+			bound = axis.Min + axis.QuantSize*float64(i)
+		}
+		bounds = append(bounds, bound/divisor)
+	}
+	return bounds
+}
+
+// Label name derived from axis name, e.g. "Request size (bytes)" becomes request_size_bytes
+func (axis histogramAxis) LabelName() string {
+	return strings.Trim(labelNameRegexp.ReplaceAllString(strings.ToLower(axis.Name), "_"), "_")
+}
+
+// Query histogram schema and dump of a single socket
+func CollectHistograms(ctx context.Context, socket string, version string, commands map[string]float64) ([]perfCounter, *socketError) {
+	commandStart := time.Now()
+	histogramSchema, _, err := GetSchema(ctx, socket, "perf histogram schema", version)
+	commands["perf histogram schema"] = time.Since(commandStart).Seconds()
+	var histogramSchemaMap map[string]interface{}
+	if err == nil {
+		histogramSchemaMap, err = LoadJson(histogramSchema)
+	}
+	if err != nil {
+		DropSchema(socket)
+		return nil, newSocketError("perf histogram schema", err)
+	}
+
+	commandStart = time.Now()
+	histogramDump, err := GetMetrics(ctx, socket, "perf histogram dump")
+	commands["perf histogram dump"] = time.Since(commandStart).Seconds()
+	if err != nil {
+		return nil, newSocketError("perf histogram dump", err)
+	}
+	var histograms map[string]map[string]perfHistogram
+	if err := json.Unmarshal([]byte(histogramDump), &histograms); err != nil {
+		return nil, newSocketError("perf histogram dump", err)
+	}
+	counters, err := ParsePerfHistograms(histogramSchemaMap, histograms)
+	if errors.Is(err, errMissingSchema) {
+		// Histograms with schema are still exported
+		DropSchema(socket)
+		return counters, newSocketError("perf histogram dump", err)
+	}
+	if err != nil {
+		return nil, newSocketError("perf histogram dump", err)
+	}
+	return counters, nil
+}
+
+// Decode histogram dump using histogram schema.
+// Histograms missing in schema are skipped and reported as errMissingSchema.
+func ParsePerfHistograms(schema map[string]interface{}, histograms map[string]map[string]perfHistogram) ([]perfCounter, error) {
+	var counters []perfCounter
+	var missing []string
+	for section, sectionHistograms := range histograms {
+		sectionSchema, ok := schema[section].(map[string]interface{})
+		if !ok {
+			missing = append(missing, section)
+			continue
+		}
+		for name, histogram := range sectionHistograms {
+			counterSchema, ok := sectionSchema[name].(map[string]interface{})
+			if !ok {
+				missing = append(missing, section+"."+name)
+				continue
+			}
+			if len(histogram.Axes) == 0 || len(histogram.Axes) > 2 {
+				return nil, fmt.Errorf("%s.%s: unsupported number of histogram axes: %d", section, name, len(histogram.Axes))
+			}
+			counterType, _ := counterSchema["type"].(float64)
+			description, _ := counterSchema["description"].(string)
+			histogram := histogram
+			counters = append(counters, perfCounter{
+				section:     section,
+				name:        name,
+				description: description,
+				counterType: perfCounterType(counterType) | perfCounterHistogram,
+				histogram:   &histogram,
+			})
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return counters, fmt.Errorf("%w: %s", errMissingSchema, strings.Join(missing, ", "))
+	}
+	return counters, nil
+}
+
+// Export histogram over first axis. Second axis, if present, is exported
+// as a label with upper bound of its bucket.
+func collectHistogram(ch chan<- prometheus.Metric, metricName string, labelNames []string, labelValues []string, counter perfCounter) {
+	histogram := counter.histogram
+	bounds := histogram.Axes[0].UpperBounds()
+
+	var secondBounds []float64
+	if len(histogram.Axes) == 2 {
+		labelNames = append(labelNames, histogram.Axes[1].LabelName())
+		secondBounds = histogram.Axes[1].UpperBounds()
+	}
+	description := CephPrometheusDesc(metricName, counter.description, labelNames...)
+
+	secondBuckets := 1
+	if len(histogram.Values) > 0 {
+		secondBuckets = len(histogram.Values[0])
+	}
+	for j := 0; j < secondBuckets; j++ {
+		buckets := make(map[float64]uint64, len(bounds))
+		var count uint64
+		for i, values := range histogram.Values {
+			if j < len(values) {
+				count += uint64(values[j])
+			}
+			if i < len(bounds) {
+				buckets[bounds[i]] = count
+			}
+		}
+		labels := append([]string{}, labelValues...)
+		if secondBounds != nil {
+			bound := "+Inf"
+			if j < len(secondBounds) {
+				bound = strconv.FormatFloat(secondBounds[j], 'f', -1, 64)
+			}
+			labels = append(labels, bound)
+		}
+		// Ceph does not track sum of observations
+		ch <- prometheus.MustNewConstHistogram(description, count, 0, buckets, labels...)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"reflect"
+	"testing"
+)
+
+func TestHistogramAxisUpperBounds(t *testing.T) {
+	latency := histogramAxis{Name: "Latency (usec)", Min: 0, QuantSize: 100000, Buckets: 5, ScaleType: "log2"}
+	if bounds := latency.UpperBounds(); !reflect.DeepEqual(bounds, []float64{0, 0.1, 0.2, 0.4}) {
+		t.Errorf("Log2 axis bounds are wrong: %v", bounds)
+	}
+	size := histogramAxis{Name: "Request size (bytes)", Min: 512, QuantSize: 512, Buckets: 4, ScaleType: "linear"}
+	if bounds := size.UpperBounds(); !reflect.DeepEqual(bounds, []float64{512, 1024, 1536}) {
+		t.Errorf("Linear axis bounds are wrong: %v", bounds)
+	}
+	if name := size.LabelName(); name != "request_size_bytes" {
+		t.Errorf("Axis label name is wrong: %s", name)
+	}
+}
+
+func TestCollectHistogram(t *testing.T) {
+	schema, _ := LoadJson(`{"osd": {"op_r_latency_out_bytes_histogram": {"type": 18, "description": "Histogram of operation latency"}}}`)
+	var dump map[string]map[string]perfHistogram
+	err := json.Unmarshal([]byte(`{"osd": {"op_r_latency_out_bytes_histogram": {
+		"axes": [
+			{"name": "Latency (usec)", "min": 0, "quant_size": 100000, "buckets": 3, "scale_type": "log2"},
+			{"name": "Request size (bytes)", "min": 0, "quant_size": 512, "buckets": 2, "scale_type": "log2"}
+		],
+		"values": [[0, 0], [3, 1], [2, 5]]
+	}}}`), &dump)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counters, err := ParsePerfHistograms(schema, dump)
+	if err != nil || len(counters) != 1 {
+		t.Fatalf("ParsePerfHistograms failed: %v, %v", counters, err)
+	}
+
+	ch := make(chan prometheus.Metric, 10)
+	CollectPerfCounters(ch, map[string]string{"type": "ceph_osd", "ceph_daemon": "osd.0", "daemon_type": "osd"}, counters)
+	close(ch)
+	if len(ch) != 2 {
+		t.Fatalf("One histogram per second axis bucket expected. Got: %d", len(ch))
+	}
+	counts := make(map[string]uint64)
+	for metric := range ch {
+		var m dto.Metric
+		if err := metric.Write(&m); err != nil {
+			t.Fatal(err)
+		}
+		for _, label := range m.GetLabel() {
+			if label.GetName() == "request_size_bytes" {
+				counts[label.GetValue()] = m.GetHistogram().GetSampleCount()
+				buckets := m.GetHistogram().GetBucket()
+				if label.GetValue() == "0" && buckets[len(buckets)-1].GetCumulativeCount() != 3 {
+					t.Errorf("Cumulative bucket count is wrong: %v", buckets)
+				}
+			}
+		}
+	}
+	if !reflect.DeepEqual(counts, map[string]uint64{"0": 5, "+Inf": 6}) {
+		t.Errorf("Histogram counts are wrong: %v", counts)
+	}
+}
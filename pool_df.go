@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	log "github.com/sirupsen/logrus"
+	"strconv"
+)
+
+// Part of `ceph df detail` output
+type cephDFDetail struct {
+	StatsByClass map[string]struct {
+		TotalBytes        float64 `json:"total_bytes"`
+		TotalAvailBytes   float64 `json:"total_avail_bytes"`
+		TotalUsedBytes    float64 `json:"total_used_bytes"`
+		TotalUsedRawBytes float64 `json:"total_used_raw_bytes"`
+	} `json:"stats_by_class"`
+	Pools []struct {
+		Name  string `json:"name"`
+		ID    int    `json:"id"`
+		Stats struct {
+			Stored             float64 `json:"stored"`
+			BytesUsed          float64 `json:"bytes_used"`
+			MaxAvail           float64 `json:"max_avail"`
+			PercentUsed        float64 `json:"percent_used"`
+			Objects            float64 `json:"objects"`
+			Dirty              float64 `json:"dirty"`
+			QuotaBytes         float64 `json:"quota_bytes"`
+			QuotaObjects       float64 `json:"quota_objects"`
+			CompressUnderBytes float64 `json:"compress_under_bytes"`
+			CompressBytesUsed  float64 `json:"compress_bytes_used"`
+			Rd                 float64 `json:"rd"`
+			RdBytes            float64 `json:"rd_bytes"`
+			Wr                 float64 `json:"wr"`
+			WrBytes            float64 `json:"wr_bytes"`
+		} `json:"stats"`
+	} `json:"pools"`
+}
+
+// Labels of every pool metric
+func PoolLabels(name string, id int) map[string]string {
+	return map[string]string{"pool": name, "pool_id": strconv.Itoa(id)}
+}
+
+// Build pool and device class capacity metrics from `ceph df detail` output
+func ParseDFDetail(data []byte) []cephHealthData {
+	var df cephDFDetail
+	if err := json.Unmarshal(data, &df); err != nil {
+		log.Debug("Failed to parse df detail: ", err)
+		return nil
+	}
+	var metrics []cephHealthData
+	for _, pool := range df.Pools {
+		labels := PoolLabels(pool.Name, pool.ID)
+		stats := pool.Stats
+		metrics = append(metrics,
+			cephHealthData{name: "ceph_pool_stored_bytes", value: stats.Stored, metricType: GaugeValue, help: "Data stored in pool before replication", labels: labels},
+			cephHealthData{name: "ceph_pool_used_bytes", value: stats.BytesUsed, metricType: GaugeValue, help: "Raw space used by pool", labels: labels},
+			cephHealthData{name: "ceph_pool_max_avail_bytes", value: stats.MaxAvail, metricType: GaugeValue, help: "Space which can still be stored in pool", labels: labels},
+			cephHealthData{name: "ceph_pool_percent_used", value: stats.PercentUsed, metricType: GaugeValue, help: "Used part of pool capacity as reported by ceph (0-1 since Nautilus)", labels: labels},
+			cephHealthData{name: "ceph_pool_objects", value: stats.Objects, metricType: GaugeValue, help: "Number of objects in pool", labels: labels},
+			cephHealthData{name: "ceph_pool_dirty_objects", value: stats.Dirty, metricType: GaugeValue, help: "Number of objects not yet flushed from cache tier", labels: labels},
+			cephHealthData{name: "ceph_pool_quota_bytes", value: stats.QuotaBytes, metricType: GaugeValue, help: "Pool quota in bytes (0:unlimited)", labels: labels},
+			cephHealthData{name: "ceph_pool_quota_objects", value: stats.QuotaObjects, metricType: GaugeValue, help: "Pool quota in objects (0:unlimited)", labels: labels},
+			cephHealthData{name: "ceph_pool_compress_under_bytes", value: stats.CompressUnderBytes, metricType: GaugeValue, help: "Data which was compressed in pool before compression", labels: labels},
+			cephHealthData{name: "ceph_pool_compress_bytes_used", value: stats.CompressBytesUsed, metricType: GaugeValue, help: "Space used by compressed data in pool", labels: labels},
+			cephHealthData{name: "ceph_pool_read_ops_total", value: stats.Rd, metricType: CounterValue, help: "Client read operations of pool", labels: labels},
+			cephHealthData{name: "ceph_pool_read_bytes_total", value: stats.RdBytes, metricType: CounterValue, help: "Client read bytes of pool", labels: labels},
+			cephHealthData{name: "ceph_pool_write_ops_total", value: stats.Wr, metricType: CounterValue, help: "Client write operations of pool", labels: labels},
+			cephHealthData{name: "ceph_pool_write_bytes_total", value: stats.WrBytes, metricType: CounterValue, help: "Client written bytes of pool", labels: labels},
+		)
+	}
+	for class, stats := range df.StatsByClass {
+		labels := map[string]string{"device_class": class}
+		metrics = append(metrics,
+			cephHealthData{name: "ceph_class_bytes_total", value: stats.TotalBytes, metricType: GaugeValue, help: "Raw capacity of device class", labels: labels},
+			cephHealthData{name: "ceph_class_bytes_avail", value: stats.TotalAvailBytes, metricType: GaugeValue, help: "Raw available capacity of device class", labels: labels},
+			cephHealthData{name: "ceph_class_bytes_used", value: stats.TotalUsedBytes, metricType: GaugeValue, help: "Space used by data of device class", labels: labels},
+			cephHealthData{name: "ceph_class_raw_bytes_used", value: stats.TotalUsedRawBytes, metricType: GaugeValue, help: "Raw used capacity of device class, including internal metadata", labels: labels},
+		)
+	}
+	return metrics
+}
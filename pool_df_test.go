@@ -0,0 +1,51 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"testing"
+)
+
+func TestParseDFDetail(t *testing.T) {
+	metrics := ParseDFDetail([]byte(`{
+		"stats": {"total_bytes": 3000, "total_avail_bytes": 2000, "total_used_bytes": 900, "total_used_raw_bytes": 1000, "total_used_raw_ratio": 0.33, "num_osds": 3, "num_per_pool_osds": 3},
+		"stats_by_class": {
+			"ssd": {"total_bytes": 3000, "total_avail_bytes": 2000, "total_used_bytes": 900, "total_used_raw_bytes": 1000, "total_used_raw_ratio": 0.33}
+		},
+		"pools": [
+			{"name": "rbd", "id": 2, "stats": {
+				"stored": 300, "stored_data": 300, "stored_omap": 0, "objects": 12, "kb_used": 1, "bytes_used": 900,
+				"data_bytes_used": 900, "omap_bytes_used": 0, "percent_used": 0.25, "max_avail": 600,
+				"quota_objects": 1000, "quota_bytes": 0, "dirty": 0, "rd": 15, "rd_bytes": 4096, "wr": 7, "wr_bytes": 8192,
+				"compress_bytes_used": 50, "compress_under_bytes": 100, "stored_raw": 900, "avail_raw": 1800
+			}}
+		]
+	}`))
+	pool := map[string]string{"pool": "rbd", "pool_id": "2"}
+	expected := map[string]float64{
+		"ceph_pool_stored_bytes":         300,
+		"ceph_pool_used_bytes":           900,
+		"ceph_pool_max_avail_bytes":      600,
+		"ceph_pool_percent_used":         0.25,
+		"ceph_pool_objects":              12,
+		"ceph_pool_quota_objects":        1000,
+		"ceph_pool_compress_under_bytes": 100,
+		"ceph_pool_read_ops_total":       15,
+		"ceph_pool_write_bytes_total":    8192,
+	}
+	for name, value := range expected {
+		metric, ok := findHealthMetric(metrics, name, pool)
+		if !ok || metric.value != value {
+			t.Errorf("Wrong %s of pool rbd. Got: %v, needed: %v", name, metric.value, value)
+		}
+	}
+	if metric, _ := findHealthMetric(metrics, "ceph_pool_read_ops_total", pool); GetDatatype(metric.metricType) != prometheus.CounterValue {
+		t.Errorf("Pool operations should be counters")
+	}
+	class, ok := findHealthMetric(metrics, "ceph_class_raw_bytes_used", map[string]string{"device_class": "ssd"})
+	if !ok || class.value != 1000 {
+		t.Errorf("Wrong raw used bytes of ssd class. Got: %v, needed: 1000", class.value)
+	}
+	if metrics := ParseDFDetail(nil); metrics != nil {
+		t.Errorf("Failed df detail should export nothing. Got: %v", metrics)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	log "github.com/sirupsen/logrus"
+)
+
+// Single pool of `ceph osd pool stats` output. Rates are omitted while pool is idle.
+type cephPoolStats struct {
+	PoolName     string `json:"pool_name"`
+	PoolID       int    `json:"pool_id"`
+	RecoveryRate struct {
+		RecoveringObjectsPerSec float64 `json:"recovering_objects_per_sec"`
+		RecoveringBytePerSec    float64 `json:"recovering_bytes_per_sec"`
+		RecoveringKeysPerSec    float64 `json:"recovering_keys_per_sec"`
+	} `json:"recovery_rate"`
+	ClientIORate struct {
+		ReadBytePerSec  float64 `json:"read_bytes_sec"`
+		WriteBytePerSec float64 `json:"write_bytes_sec"`
+		ReadOpPerSec    float64 `json:"read_op_per_sec"`
+		WriteOpPerSec   float64 `json:"write_op_per_sec"`
+	} `json:"client_io_rate"`
+}
+
+// Build per pool client and recovery IO rates from `ceph osd pool stats` output
+func ParsePoolStats(data []byte) []cephHealthData {
+	var pools []cephPoolStats
+	if err := json.Unmarshal(data, &pools); err != nil {
+		log.Debug("Failed to parse osd pool stats: ", err)
+		return nil
+	}
+	var metrics []cephHealthData
+	for _, pool := range pools {
+		labels := PoolLabels(pool.PoolName, pool.PoolID)
+		client := pool.ClientIORate
+		recovery := pool.RecoveryRate
+		metrics = append(metrics,
+			cephHealthData{name: "ceph_pool_read_ops_per_second", value: client.ReadOpPerSec, metricType: GaugeValue, help: "Client read operations per second of pool", labels: labels},
+			cephHealthData{name: "ceph_pool_write_ops_per_second", value: client.WriteOpPerSec, metricType: GaugeValue, help: "Client write operations per second of pool", labels: labels},
+			cephHealthData{name: "ceph_pool_read_bytes_per_second", value: client.ReadBytePerSec, metricType: GaugeValue, help: "Client read bytes per second of pool", labels: labels},
+			cephHealthData{name: "ceph_pool_write_bytes_per_second", value: client.WriteBytePerSec, metricType: GaugeValue, help: "Client written bytes per second of pool", labels: labels},
+			cephHealthData{name: "ceph_pool_recovering_objects_per_second", value: recovery.RecoveringObjectsPerSec, metricType: GaugeValue, help: "Recovered objects per second of pool", labels: labels},
+			cephHealthData{name: "ceph_pool_recovering_bytes_per_second", value: recovery.RecoveringBytePerSec, metricType: GaugeValue, help: "Recovered bytes per second of pool", labels: labels},
+			cephHealthData{name: "ceph_pool_recovering_keys_per_second", value: recovery.RecoveringKeysPerSec, metricType: GaugeValue, help: "Recovered omap keys per second of pool", labels: labels},
+		)
+	}
+	return metrics
+}
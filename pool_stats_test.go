@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+func TestParsePoolStats(t *testing.T) {
+	metrics := ParsePoolStats([]byte(`[
+		{"pool_name": "rbd", "pool_id": 2, "recovery": {}, "recovery_rate": {}, "client_io_rate": {}},
+		{"pool_name": "tenant-volumes", "pool_id": 5,
+			"recovery": {"degraded_objects": 10, "degraded_total": 300, "degraded_ratio": 0.033},
+			"recovery_rate": {"recovering_objects_per_sec": 4, "recovering_bytes_per_sec": 16384, "recovering_keys_per_sec": 0, "num_objects_recovered": 8, "num_bytes_recovered": 32768, "num_keys_recovered": 0},
+			"client_io_rate": {"read_bytes_sec": 2048, "write_bytes_sec": 409600, "read_op_per_sec": 2, "write_op_per_sec": 100}}
+	]`))
+	tenant := map[string]string{"pool": "tenant-volumes", "pool_id": "5"}
+	expected := map[string]float64{
+		"ceph_pool_read_ops_per_second":           2,
+		"ceph_pool_write_ops_per_second":          100,
+		"ceph_pool_read_bytes_per_second":         2048,
+		"ceph_pool_write_bytes_per_second":        409600,
+		"ceph_pool_recovering_objects_per_second": 4,
+		"ceph_pool_recovering_bytes_per_second":   16384,
+	}
+	for name, value := range expected {
+		metric, ok := findHealthMetric(metrics, name, tenant)
+		if !ok || metric.value != value {
+			t.Errorf("Wrong %s of pool tenant-volumes. Got: %v, needed: %v", name, metric.value, value)
+		}
+	}
+	// Idle pool is exported with zero rates
+	idle, ok := findHealthMetric(metrics, "ceph_pool_write_ops_per_second", map[string]string{"pool": "rbd", "pool_id": "2"})
+	if !ok || idle.value != 0 {
+		t.Errorf("Idle pool should have zero rates. Got: %v", idle)
+	}
+	if metrics := ParsePoolStats(nil); metrics != nil {
+		t.Errorf("Failed osd pool stats should export nothing. Got: %v", metrics)
+	}
+}
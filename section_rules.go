@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Rule collapsing family of instance suffixed perf sections into a single section.
+// Named groups of match expression become labels, e.g. section throttle-osd_client_bytes
+// matched by `^throttle-(?P<throttle>.+)$` is exported as throttle{throttle="osd_client_bytes"}.
+// Section is a template, it may refer to groups of match expression ($1, ${name}).
+type sectionRule struct {
+	Match   string `json:"match"`
+	Section string `json:"section"`
+	re      *regexp.Regexp
+}
+
+var defaultSectionRules = []sectionRule{
+	// RGW instances are named by hostname, zone, port or container id
+	{Match: `^client\.(?:radosgw|rgw)\.(?P<rgw_instance>.+)$`, Section: "client.radosgw"},
+	{Match: `^throttle-(?P<throttle>.+)$`, Section: "throttle"},
+	{Match: `^AsyncMessenger::Worker-(?P<worker>\d+)$`, Section: "msgr_worker"},
+	{Match: `^finisher-(?P<finisher>.+)$`, Section: "finisher"},
+	// bluestore-pricache before Octopus, prioritycache since
+	{Match: `^(?:bluestore-pricache|prioritycache):(?P<cache>.+)$`, Section: "bluestore_pricache"},
+	// librbd-<image id>-<pool>[/<namespace>]-<image>. Image id keeps series unique,
+	// pool and image are split using images opened by client (see ResolvePoolLabel).
+	{Match: `^librbd-(?P<image_id>[0-9a-f]+)-(?P<pool>[^/]+?)(?:/(?P<namespace>[^/]*?))?-(?P<image>[^/]+)$`, Section: "librbd"},
+}
+
+var sectionRules = mustCompileSectionRules(defaultSectionRules)
+
+// References to match groups in section template: $$, ${name}, $name or $1
+var templateRefRegexp = regexp.MustCompile(`\$(?:\$|\{(\w+)\}|(\w+))`)
+
+// Section becomes part of metric name after CephNormalizeMetricName
+var validSectionRegexp = regexp.MustCompile(`^[a-zA-Z0-9_.:-]+$`)
+var invalidSectionRegexp = regexp.MustCompile(`[^a-zA-Z0-9_.:-]`)
+
+// Named groups become prometheus labels, names starting with __ are reserved
+var validLabelNameRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+func hasSubexp(re *regexp.Regexp, name string) bool {
+	for _, subexp := range re.SubexpNames() {
+		if subexp == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Check that section template refers only to existing groups and expands to valid metric name part
+func validateSectionTemplate(re *regexp.Regexp, section string) error {
+	var err error
+	expanded := templateRefRegexp.ReplaceAllStringFunc(section, func(ref string) string {
+		parts := templateRefRegexp.FindStringSubmatch(ref)
+		name := parts[1] + parts[2]
+		if name == "" {
+			// Literal $ is not valid in metric name
+			return "$"
+		}
+		if number, convErr := strconv.Atoi(name); convErr == nil {
+			if number > re.NumSubexp() {
+				err = fmt.Errorf("section %q refers to missing group %s", section, ref)
+			}
+		} else if !hasSubexp(re, name) {
+			err = fmt.Errorf("section %q refers to missing group %s", section, ref)
+		}
+		// Group text is sanitized when rule is applied
+		return "x"
+	})
+	if err != nil {
+		return err
+	}
+	if !validSectionRegexp.MatchString(expanded) {
+		return fmt.Errorf("section %q is not a valid metric name part", section)
+	}
+	return nil
+}
+
+func compileSectionRules(rules []sectionRule) ([]sectionRule, error) {
+	compiled := make([]sectionRule, 0, len(rules))
+	for _, rule := range rules {
+		re, err := regexp.Compile(rule.Match)
+		if err != nil {
+			return nil, fmt.Errorf("section rule %q: %w", rule.Match, err)
+		}
+		if rule.Section == "" {
+			return nil, fmt.Errorf("section rule %q: section is empty", rule.Match)
+		}
+		if err := validateSectionTemplate(re, rule.Section); err != nil {
+			return nil, fmt.Errorf("section rule %q: %w", rule.Match, err)
+		}
+		for _, name := range re.SubexpNames() {
+			if name != "" && (!validLabelNameRegexp.MatchString(name) || strings.HasPrefix(name, "__")) {
+				return nil, fmt.Errorf("section rule %q: group %q is not a valid label name", rule.Match, name)
+			}
+		}
+		rule.re = re
+		compiled = append(compiled, rule)
+	}
+	return compiled, nil
+}
+
+func mustCompileSectionRules(rules []sectionRule) []sectionRule {
+	compiled, err := compileSectionRules(rules)
+	if err != nil {
+		panic(err)
+	}
+	return compiled
+}
+
+// Replace built-in section rules with rules from JSON file:
+// [{"match": "^throttle-(?P<throttle>.+)$", "section": "throttle"}, ...]
+func LoadSectionRules(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var rules []sectionRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	compiled, err := compileSectionRules(rules)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	sectionRules = compiled
+	return nil
+}
+
+// Rename counter sections matched by section rules and move instance parts into labels.
+// First matching rule wins. Counters which end up as duplicate metrics are dropped.
+// Images opened by librbd client are used to split pool and image labels, see ResolvePoolLabel.
+func ApplySectionRules(counters []perfCounter, images []rbdImage) []perfCounter {
+	for i, counter := range counters {
+		for _, rule := range sectionRules {
+			match := rule.re.FindStringSubmatchIndex(counter.section)
+			if match == nil {
+				continue
+			}
+			labels := make(map[string]string, len(counter.labels)+1)
+			for name, value := range counter.labels {
+				labels[name] = value
+			}
+			for group, name := range rule.re.SubexpNames() {
+				if name != "" {
+					labels[CephLabelName(name)] = submatch(counter.section, match, group)
+				}
+			}
+			ResolvePoolLabel(labels, images)
+			section := string(rule.re.ExpandString(nil, rule.Section, counter.section, match))
+			counters[i].section = invalidSectionRegexp.ReplaceAllString(section, "_")
+			counters[i].labels = labels
+			break
+		}
+	}
+	return DedupeCounters(FillLabelSets(counters))
+}
+
+// Drop counters exported under the same metric name and label values as previous ones.
+// Such duplicates (e.g. produced by too broad rule) would fail the whole scrape.
+func DedupeCounters(counters []perfCounter) []perfCounter {
+	seen := make(map[string]bool, len(counters))
+	deduped := counters[:0]
+	for _, counter := range counters {
+		names, values := counter.LabelPairs()
+		key := CephNormalizeMetricName(counter.section) + "_" + counter.name
+		for i, name := range names {
+			key += "\xff" + name + "=" + values[i]
+		}
+		if seen[key] {
+			log.Warn("Dropping duplicate counter ", counter.section, ".", counter.name, " with labels ", counter.labels)
+			continue
+		}
+		seen[key] = true
+		deduped = append(deduped, counter)
+	}
+	return deduped
+}
+
+// Text of group from FindStringSubmatchIndex result, empty when group did not participate
+func submatch(s string, match []int, group int) string {
+	if match[2*group] < 0 {
+		return ""
+	}
+	return s[match[2*group]:match[2*group+1]]
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestApplySectionRules(t *testing.T) {
+	counters := ApplySectionRules([]perfCounter{
+		{section: "throttle-osd_client_bytes", name: "val"},
+		{section: "throttle-msgr_dispatch_throttler-client", name: "val"},
+		{section: "AsyncMessenger::Worker-0", name: "msgr_recv_messages"},
+		{section: "bluestore-pricache:kv", name: "pri0_bytes"},
+		{section: "prioritycache:onode", name: "pri0_bytes"},
+		{section: "librbd-10226b8b4567-rbd-vm-100-disk-0", name: "rd"},
+		{section: "librbd-5e6f7a8b9c0d-cinder-volumes/tenant1-volume-1", name: "rd"},
+		{section: "client.radosgw.host1", name: "req"},
+		{section: "client.rgw.zone1.8080", name: "req"},
+		{section: "osd", name: "op"},
+	}, nil)
+	expected := []perfCounter{
+		{section: "throttle", name: "val", labels: map[string]string{"throttle": "osd_client_bytes"}},
+		{section: "throttle", name: "val", labels: map[string]string{"throttle": "msgr_dispatch_throttler-client"}},
+		{section: "msgr_worker", name: "msgr_recv_messages", labels: map[string]string{"worker": "0"}},
+		{section: "bluestore_pricache", name: "pri0_bytes", labels: map[string]string{"cache": "kv"}},
+		{section: "bluestore_pricache", name: "pri0_bytes", labels: map[string]string{"cache": "onode"}},
+		{section: "librbd", name: "rd", labels: map[string]string{"image_id": "10226b8b4567", "pool": "rbd", "namespace": "", "image": "vm-100-disk-0"}},
+		{section: "librbd", name: "rd", labels: map[string]string{"image_id": "5e6f7a8b9c0d", "pool": "cinder-volumes", "namespace": "tenant1", "image": "volume-1"}},
+		{section: "client.radosgw", name: "req", labels: map[string]string{"rgw_instance": "host1"}},
+		{section: "client.radosgw", name: "req", labels: map[string]string{"rgw_instance": "zone1.8080"}},
+		{section: "osd", name: "op"},
+	}
+	if !reflect.DeepEqual(counters, expected) {
+		t.Errorf("ApplySectionRules failed. Got: %v, needed: %v", counters, expected)
+	}
+}
+
+func TestLoadSectionRules(t *testing.T) {
+	defer func() { sectionRules = mustCompileSectionRules(defaultSectionRules) }()
+	file, err := ioutil.TempFile("", "ceph-exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.WriteString(`[
+		{"match": "^objecter-(?P<client>.+)$", "section": "objecter"},
+		{"match": "^mds_cache-(\\w+)-(?P<rank>\\d+)$", "section": "mds_cache_$1"}
+	]`); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := LoadSectionRules(file.Name()); err != nil {
+		t.Fatalf("LoadSectionRules failed: %v", err)
+	}
+	counters := ApplySectionRules([]perfCounter{
+		{section: "objecter-rgw", name: "op"},
+		{section: "throttle-osd_client_bytes", name: "val"},
+		{section: "mds_cache-fs1-0", name: "strays"},
+	}, nil)
+	if counters[0].section != "objecter" || counters[0].labels["client"] != "rgw" {
+		t.Errorf("Rule from file was not applied: %v", counters[0])
+	}
+	if counters[1].section != "throttle-osd_client_bytes" {
+		t.Errorf("Built-in rules should be replaced: %v", counters[1])
+	}
+	if counters[2].section != "mds_cache_fs1" || counters[2].labels["rank"] != "0" {
+		t.Errorf("Section template was not expanded: %v", counters[2])
+	}
+
+	for _, rules := range []string{
+		`[{"match": "(", "section": "broken"}]`,
+		`[{"match": "^objecter-(?P<client>.+)$", "section": "objecter ${client}"}]`,
+		`[{"match": "^objecter-(?P<client>.+)$", "section": "objecter_${missing}"}]`,
+		`[{"match": "^objecter-(.+)$", "section": "objecter_$2"}]`,
+		`[{"match": "^objecter-(.+)$", "section": "objecter$$"}]`,
+		`[{"match": "^objecter-(?P<__client>.+)$", "section": "objecter"}]`,
+		`[{"match": "^objecter-(?P<1client>.+)$", "section": "objecter"}]`,
+	} {
+		if err := ioutil.WriteFile(file.Name(), []byte(rules), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := LoadSectionRules(file.Name()); err == nil {
+			t.Errorf("Invalid rule should be rejected: %s", rules)
+		}
+	}
+}
+
+func TestApplySectionRulesDuplicates(t *testing.T) {
+	defer func() { sectionRules = mustCompileSectionRules(defaultSectionRules) }()
+	sectionRules = mustCompileSectionRules([]sectionRule{
+		// Too broad rule, drops instance part
+		{Match: `^objecter-.+$`, Section: "objecter"},
+		{Match: `^cache-(.+)$`, Section: "cache_$1"},
+	})
+	counters := ApplySectionRules([]perfCounter{
+		{section: "objecter-rgw", name: "op", value: 1},
+		{section: "objecter-rbd", name: "op", value: 2},
+		{section: "objecter-rbd", name: "op_r", value: 3},
+		{section: "cache-a b", name: "hits"},
+	}, nil)
+	if len(counters) != 3 || counters[0].value != 1 || counters[1].name != "op_r" {
+		t.Errorf("Duplicate counters should be dropped. Got: %v", counters)
+	}
+	if counters[2].section != "cache_a_b" {
+		t.Errorf("Expanded section should be sanitized. Got: %s", counters[2].section)
+	}
+}
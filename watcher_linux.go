@@ -0,0 +1,84 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+	"path/filepath"
+	"strings"
+	"unsafe"
+)
+
+const watchMask = unix.IN_CREATE | unix.IN_DELETE | unix.IN_MOVED_TO | unix.IN_MOVED_FROM
+
+// Watch admin socket directories with inotify and trigger collection
+// as soon as socket is created or removed.
+// Directories created inside watched ones (e.g. cephadm <fsid> directory) are watched too.
+func WatchSockets(dirs []string) error {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC)
+	if err != nil {
+		return err
+	}
+	watches := make(map[int32]string)
+	for _, dir := range dirs {
+		log.Debug("Watching admin socket directory: ", dir)
+		wd, err := unix.InotifyAddWatch(fd, dir, watchMask)
+		if err != nil {
+			unix.Close(fd)
+			return err
+		}
+		watches[int32(wd)] = dir
+	}
+	go readSocketEvents(fd, watches)
+	return nil
+}
+
+func readSocketEvents(fd int, watches map[int32]string) {
+	defer unix.Close(fd)
+	buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	for {
+		n, err := unix.Read(fd, buf)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			log.Error("Admin socket watcher failed, relying on periodic rescan: ", err)
+			return
+		}
+		trigger := false
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + unix.SizeofInotifyEvent
+			name := strings.TrimRight(string(buf[nameStart:nameStart+int(event.Len)]), "\x00")
+			offset = nameStart + int(event.Len)
+
+			switch {
+			case event.Mask&unix.IN_Q_OVERFLOW != 0:
+				log.Debug("Admin socket watcher queue overflow")
+				trigger = true
+			case event.Mask&unix.IN_IGNORED != 0:
+				log.Warn("Admin socket directory is no longer watched, relying on periodic rescan: ", watches[event.Wd])
+				delete(watches, event.Wd)
+			case event.Mask&unix.IN_CREATE != 0 && event.Mask&unix.IN_ISDIR != 0:
+				dir := filepath.Join(watches[event.Wd], name)
+				log.Debug("Watching new admin socket directory: ", dir)
+				wd, err := unix.InotifyAddWatch(fd, dir, watchMask)
+				if err != nil {
+					log.Warn("Failed to watch new admin socket directory ", dir, ": ", err)
+				} else {
+					watches[int32(wd)] = dir
+				}
+				// Sockets could have been created before watch was added
+				trigger = true
+			case strings.HasSuffix(name, ".asok"):
+				log.Debug("Admin socket event ", event.Mask, ": ", name)
+				trigger = true
+			}
+		}
+		if trigger {
+			TriggerCollection()
+		}
+	}
+}
@@ -0,0 +1,40 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchSockets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ceph-exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := WatchSockets([]string{dir}); err != nil {
+		t.Fatalf("WatchSockets failed: %v", err)
+	}
+	// Drain pending trigger left by other tests
+	select {
+	case <-collectTrigger:
+	default:
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "ceph.conf"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "ceph-osd.3.asok"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-collectTrigger:
+	case <-time.After(time.Second):
+		t.Errorf("Socket creation did not trigger collection")
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "errors"
+
+// Filesystem watching is implemented only with linux inotify
+func WatchSockets(dirs []string) error {
+	return errors.New("admin socket watching is supported only on linux")
+}